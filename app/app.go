@@ -2,11 +2,13 @@ package app
 
 import (
 	"log"
+	"math"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/null-enjoyer/terminal-fluid-simulation/config"
 	"github.com/null-enjoyer/terminal-fluid-simulation/render"
+	"github.com/null-enjoyer/terminal-fluid-simulation/replay"
 	"github.com/null-enjoyer/terminal-fluid-simulation/simulation"
 	"github.com/null-enjoyer/terminal-fluid-simulation/ui"
 )
@@ -16,8 +18,26 @@ const (
 	ModeSpawn = iota
 	ModeWall
 	ModeErase
+	ModeEmitter
+	ModeDrain
+	ModeAttract
+	ModeRepel
+	ModeDrag
+	ModeHeat
+	ModeCool
+	ModeBody
 )
 
+// text input actions
+const (
+	InputSavePreset = iota
+	InputSaveScene
+	InputLoadScene
+)
+
+// DefaultRenderFPS is the number of frames drawn per second unless configured
+const DefaultRenderFPS = 60
+
 type App struct {
 	Screen    tcell.Screen
 	Sim       *simulation.Simulation
@@ -25,36 +45,118 @@ type App struct {
 	Palettes  []render.Palette
 
 	ConfigPath string
+	ScenePath  string
 	UIConfig   config.PhysicsConfig
+	Seed       int64 // command line seed, kept across preset and scene changes
+	RecordPath string
+	Recorder   *replay.Writer
 
 	MenuItems   []ui.MenuItem
+	MenuScroll  int // first menu item shown when the menu does not fit
 	PresetNames []string
 
+	// Species
+	Species         []config.Species // species of the simulation, fixed once it runs
+	SpawnSpecies    int
+	SpeciesPalettes []int // palette index per species, -1 follows the preset palette
+
+	// Emitter settings for newly placed emitters
+	EmitRate  float64
+	EmitAngle float64
+	EmitSpeed float64
+
+	// WallMaterialIdx is the material the wall tool draws
+	WallMaterialIdx int
+
+	// Wall tool and brush size in terminal cells, the brush also sets the spawn area.
+	// brushMask marks the view cells a stroke or shape covers until they are sent.
+	WallToolIdx int
+	BrushSize   float64
+	brushMask   []bool
+	fillQueue   []int
+
+	// freehand stroke and shape being drawn, in terminal cells of the view
+	strokeX, strokeY     int
+	stroking             bool
+	shapeX, shapeY       int
+	shapeEndX, shapeEndY int
+	shaping              bool
+
+	// Force brush, the velocity follows the cursor for the drag tool
+	ForceRadius   float64
+	ForceStrength float64
+	ForceActive   bool
+	LastCursorX   float64
+	LastCursorY   float64
+	CursorVX      float64
+	CursorVY      float64
+
+	// Rigid bodies placed with the body tool, pressing on a body drags it instead
+	BodyShapeIdx int
+	BodySize     float64
+	BodyDensity  float64
+	BodyPressed  bool
+	BodyDragging bool
+
 	// Input
 	CursorX, CursorY float64
 	SelectedItem     int
 	ActivePresetName string
 	ActivePresetIdx  int
 	InputMode        bool
+	InputAction      int
 	InputText        string
+	StatusMsg        string
 
 	// Mouse State
 	MouseMode     int
 	IsMouseDown   bool
 	MouseInBounds bool
 
-	// Particles
+	// Particles, the grids use simulation cells
 	CurrentParticles []render.Point
-	CurrentGrid      [][]int
-	LastGrid         [][]int
+	CurrentSources   []render.Source
+	CurrentBodies    []render.Body
+	CurrentWalls     *render.Walls
+	frame            *render.FrameSnapshot // the Current fields point into its buffers
+	CurrentGrid      []int                 // per simulation cell, indexed x + y*SimW
+	CurrentSpecies   []int
+	FieldSum         []float64 // weighted sum of the color mode value per cell
+	CurrentCover     []int     // walls and bodies per cell, see coverWall
 	SimW, SimH       int
-	LastCursorX      int
-	LastCursorY      int
+
+	// Color mode, the range maps values onto the active palette
+	ColorModeIdx int
+	AutoRange    bool
+	RangeValid   bool // false until the auto range saw its first frame
+	RangeMin     float64
+	RangeMax     float64
+
+	// Terminal cells, each covers Mode.ScaleX x Mode.ScaleY simulation cells
+	Mode         render.Mode
+	ViewW, ViewH int
+	LastCells    []render.Cell // per terminal cell, indexed x + y*ViewW
+	Markers      []render.Cell // source glyphs drawn over the fluid, zero Rune for none
+	PixelBuf     []tcell.Color
 
 	// UI Styling
 	StyleBorder  tcell.Style
 	StyleMenuBg  tcell.Style
 	StyleMenuSel tcell.Style
+	footer       []footerLine // sidebar lines below the menu, see layoutFooter
+	footerText   []byte
+	menuText     []byte // the sidebar line being drawn
+
+	// Simulation state from the last snapshot
+	Frame     uint64
+	Simulated uint64 // frames of physics run, see Simulation.Simulated
+	SubStep   int
+	Rewind    int // frames behind the newest one of the rewind buffer
+	RewindLen int
+	// RewindFrames is the depth of the rewind buffer, zero when it is off
+	RewindFrames int
+	Recording    bool
+	Replaying    bool
 
 	// Debug Info
 	Fps            int
@@ -62,12 +164,36 @@ type App struct {
 	FpsTimer       time.Time
 	LastPhysTime   time.Duration
 	LastRenderTime time.Duration
+	AvgPhysTime    time.Duration // smoothed over recent frames for the budget warning
+	FrameInterval  time.Duration // time between drawn frames
+	StepInterval   time.Duration // time between simulation steps
+	TimeScale      float64
+	SubSteps       int
+	MaxParticles   int         // particle cap of the simulation, zero or less is unlimited
+	stats          *frameStats // frame times recorded for the harness, nil otherwise
 }
 
-func New(configPath string) *App {
+// Options are the command line settings of the interactive app
+type Options struct {
+	ConfigPath      string
+	ScenePath       string
+	Workers         int     // overrides the config file when > 0
+	GatherPositions bool    // grid ordered position copies for the neighbor search, see Simulation.GatherPositions
+	MaxParticles    int     // overrides the config file when non-zero, negative is unlimited
+	PhysicsRate     float64 // overrides the config file when > 0
+	RenderFPS       int     // overrides the config file when > 0
+	RewindFrames    int     // overrides the config file when > 0
+	Seed            int64   // enables deterministic mode with this seed when non-zero
+	RecordPath      string  // starts recording to this file right away
+	ReplayPath      string
+	Screen          tcell.Screen // the terminal when nil
+}
+
+func New(opts Options) *App {
 	var appConfig *config.AppConfig
 	var err error
 
+	configPath := opts.ConfigPath
 	if configPath != "" {
 		appConfig, err = config.LoadSettings(configPath)
 		if err != nil {
@@ -87,17 +213,55 @@ func New(configPath string) *App {
 		log.Fatal("Default config not found in settings.json")
 	}
 
-	screen, err := tcell.NewScreen()
-	if err != nil {
-		panic(err)
+	screen := opts.Screen
+	if screen == nil {
+		if screen, err = tcell.NewScreen(); err != nil {
+			panic(err)
+		}
 	}
 	if err := screen.Init(); err != nil {
 		panic(err)
 	}
 	screen.EnableMouse()
 
+	if opts.Seed != 0 {
+		defaultCfg.Seed = opts.Seed
+	}
+
 	w, h := screen.Size()
-	sim := simulation.NewSimulation(w, h, defaultCfg)
+	simW, simH := simulation.DomainSize(w, h)
+	sim := simulation.NewSimulation(simW, simH, defaultCfg, appConfig.Species)
+
+	workers := appConfig.Workers
+	if opts.Workers > 0 {
+		workers = opts.Workers
+	}
+	if workers > 0 {
+		sim.SetWorkers(workers)
+	}
+	sim.GatherPositions = opts.GatherPositions
+	maxParticles := appConfig.MaxParticles
+	if opts.MaxParticles != 0 {
+		maxParticles = opts.MaxParticles
+	}
+	if maxParticles != 0 {
+		sim.MaxParticles = maxParticles
+	}
+	sim.StepRate = appConfig.PhysicsRate
+	if opts.PhysicsRate > 0 {
+		sim.StepRate = opts.PhysicsRate
+	}
+	sim.RewindFrames = appConfig.RewindFrames
+	if opts.RewindFrames > 0 {
+		sim.RewindFrames = opts.RewindFrames
+	}
+	renderFPS := appConfig.RenderFPS
+	if opts.RenderFPS > 0 {
+		renderFPS = opts.RenderFPS
+	}
+	if renderFPS <= 0 {
+		renderFPS = DefaultRenderFPS
+	}
 
 	app := &App{
 		Screen:           screen,
@@ -106,27 +270,38 @@ func New(configPath string) *App {
 		Palettes:         palettes,
 		ConfigPath:       configPath,
 		UIConfig:         sim.Config,
-		CursorX:          float64((w - simulation.SidebarWidth) / 2),
+		Species:          sim.Species,
+		Seed:             opts.Seed,
+		RecordPath:       opts.RecordPath,
+		CursorX:          float64(simW / 2),
 		CursorY:          float64(10),
 		ActivePresetName: "Default",
 		ActivePresetIdx:  0,
+		FrameInterval:    time.Second / time.Duration(renderFPS),
+		StepInterval:     sim.StepInterval(),
+		TimeScale:        1,
 		MouseMode:        ModeSpawn,
+		EmitRate:         2,
+		EmitAngle:        90,
+		EmitSpeed:        0.5,
+		ForceRadius:      6,
+		ForceStrength:    0.3,
+		BodySize:         8,
+		BrushSize:        1,
+		BodyDensity:      0.5,
 		SimW:             sim.Width,
 		SimH:             sim.Height,
-		LastCursorX:      -1,
-		LastCursorY:      -1,
+		Mode:             render.Modes[0],
+		ViewW:            simW,
+		ViewH:            simH,
+		PixelBuf:         make([]tcell.Color, 8),
 		StyleBorder:      tcell.StyleDefault.Foreground(tcell.ColorWhite),
 		StyleMenuBg:      tcell.StyleDefault.Background(tcell.ColorBlack),
 		StyleMenuSel:     tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorYellow),
 		FpsTimer:         time.Now(),
 	}
 
-	app.CurrentGrid = make([][]int, app.SimW)
-	app.LastGrid = make([][]int, app.SimW)
-	for i := range app.CurrentGrid {
-		app.CurrentGrid[i] = make([]int, app.SimH)
-		app.LastGrid[i] = make([]int, app.SimH)
-	}
+	app.AllocGrids()
 
 	app.PresetNames = config.GetSortedPresetNames(appConfig.Presets)
 	for i, name := range app.PresetNames {
@@ -137,13 +312,41 @@ func New(configPath string) *App {
 	}
 
 	app.SyncPalette()
+	app.SyncSpawnSpecies()
+	app.SyncSpeciesPalettes()
+	app.SyncRenderMode()
+	app.ApplyRenderMode()
 	app.InitMenu()
 
+	if opts.ScenePath != "" {
+		if err := app.loadStartupScene(opts.ScenePath); err != nil {
+			screen.Fini()
+			log.Fatalf("Failed to load scene: %v", err)
+		}
+	}
+
+	if opts.ReplayPath != "" {
+		if err := app.startReplay(opts.ReplayPath); err != nil {
+			screen.Fini()
+			log.Fatalf("Failed to load recording: %v", err)
+		}
+	}
+	// replays use the rewind buffer of the recording
+	app.RewindFrames = sim.RewindFrames
+
 	return app
 }
 
 func (a *App) Run() {
 	go a.Sim.Run()
+	defer a.Sim.Close()
+	defer a.StopRecording()
+
+	if a.RecordPath != "" {
+		if err := a.StartRecording(a.RecordPath); err != nil {
+			a.StatusMsg = err.Error()
+		}
+	}
 
 	events := make(chan tcell.Event)
 	go func() {
@@ -152,7 +355,7 @@ func (a *App) Run() {
 		}
 	}()
 
-	ticker := time.NewTicker(time.Millisecond * 16)
+	ticker := time.NewTicker(a.FrameInterval)
 	defer ticker.Stop()
 
 	for {
@@ -169,8 +372,7 @@ func (a *App) Run() {
 				a.HandleMouse(ev)
 			}
 		case snapshot := <-a.Sim.RenderChan:
-			a.CurrentParticles = snapshot.Points
-			a.LastPhysTime = snapshot.CalcTime
+			a.applyFrame(snapshot)
 		case <-ticker.C:
 			a.HandleContinuousInput()
 			a.Render()
@@ -178,21 +380,165 @@ func (a *App) Run() {
 	}
 }
 
+// applyFrame takes over a frame from the simulation, it is drawn by the next Render
+func (a *App) applyFrame(snapshot *render.FrameSnapshot) {
+	// the buffers of the previous frame are no longer drawn from
+	a.Sim.ReleaseFrame(a.frame)
+	a.frame = snapshot
+	a.CurrentParticles = snapshot.Points
+	a.CurrentSources = snapshot.Sources
+	a.CurrentBodies = snapshot.Bodies
+	a.CurrentWalls = snapshot.Walls
+	a.LastPhysTime = snapshot.CalcTime
+	if a.stats != nil {
+		a.stats.addPhys(snapshot.CalcTime)
+	}
+	a.AvgPhysTime = (a.AvgPhysTime*7 + snapshot.CalcTime) / 8
+	a.MaxParticles = snapshot.MaxParticles
+	a.StepInterval = snapshot.StepInterval
+	a.TimeScale = snapshot.TimeScale
+	a.SubSteps = snapshot.SubSteps
+	a.Frame = snapshot.Frame
+	a.Simulated = snapshot.Simulated
+	a.SubStep = snapshot.SubStep
+	a.Rewind = snapshot.Rewind
+	a.RewindLen = snapshot.RewindLen
+	a.Recording = snapshot.Recording
+	a.Replaying = snapshot.Replaying
+	// a failed write ended the recording, close the file and show why
+	if snapshot.RecordErr != nil && a.Recorder != nil {
+		a.StopRecording()
+	}
+	// replays and pending resizes can run the simulation at another size than the view
+	if snapshot.Width != a.SimW || snapshot.Height != a.SimH {
+		a.SimW, a.SimH = snapshot.Width, snapshot.Height
+		a.AllocGrids()
+	}
+}
+
 func (a *App) HandleContinuousInput() {
+	// cursor velocity in cells per tick, smoothed since the cursor jumps between cells
+	a.CursorVX = a.CursorVX*0.5 + (a.CursorX-a.LastCursorX)*0.5
+	a.CursorVY = a.CursorVY*0.5 + (a.CursorY-a.LastCursorY)*0.5
+	a.LastCursorX, a.LastCursorY = a.CursorX, a.CursorY
+
+	if a.IsForceMode() {
+		if a.IsMouseDown && a.MouseInBounds {
+			kind := simulation.ForceDrag
+			if a.MouseMode == ModeAttract {
+				kind = simulation.ForceAttract
+			} else if a.MouseMode == ModeRepel {
+				kind = simulation.ForceRepel
+			}
+			a.Sim.CmdChan <- simulation.Command{Kind: simulation.CmdForce, Force: &simulation.Force{
+				Kind:     kind,
+				X:        a.CursorX + 0.5,
+				Y:        a.CursorY + 0.5,
+				VX:       a.CursorVX,
+				VY:       a.CursorVY,
+				Radius:   a.ForceRadius,
+				Strength: a.ForceStrength,
+			}}
+			a.ForceActive = true
+			return
+		}
+	}
+	if a.ForceActive {
+		a.Sim.CmdChan <- simulation.Command{Kind: simulation.CmdForce}
+		a.ForceActive = false
+	}
+	if !a.IsMouseDown || !a.MouseInBounds || a.MouseMode != ModeBody {
+		if a.BodyDragging {
+			a.Sim.CmdChan <- simulation.Command{Kind: simulation.CmdDragBody}
+		}
+		a.BodyPressed = false
+		a.BodyDragging = false
+	}
+
 	if a.IsMouseDown && a.MouseInBounds {
 		cx, cy := a.CursorX, a.CursorY
 
 		switch a.MouseMode {
 		case ModeSpawn:
-			a.Sim.CmdChan <- func(s *simulation.Simulation) { s.Spawn(cx, cy) }
-		case ModeWall:
-			ix, iy := int(cx), int(cy)
-			a.Sim.CmdChan <- func(s *simulation.Simulation) { s.SetWall(ix, iy, true) }
-		case ModeErase:
-			ix, iy := int(cx), int(cy)
-			a.Sim.CmdChan <- func(s *simulation.Simulation) { s.SetWall(ix, iy, false) }
+			a.Sim.CmdChan <- a.spawnCommand()
+		case ModeEmitter, ModeDrain:
+			x0, y0 := a.cellOrigin(cx, cy)
+			src := &simulation.Source{Kind: simulation.SourceDrain}
+			if a.MouseMode == ModeEmitter {
+				src = &simulation.Source{
+					Kind:    simulation.SourceEmitter,
+					Species: a.SpawnSpecies,
+					Rate:    a.EmitRate,
+					Angle:   a.EmitAngle,
+					Speed:   a.EmitSpeed,
+				}
+			}
+			a.Sim.CmdChan <- simulation.Command{
+				Kind:   simulation.CmdAddSource,
+				X:      float64(x0),
+				Y:      float64(y0),
+				Width:  a.Mode.ScaleX,
+				Height: a.Mode.ScaleY,
+				Source: src,
+			}
+		case ModeBody:
+			// one body per click, a click on a body grabs it
+			x, y := cx+0.5, cy+0.5
+			if !a.BodyPressed {
+				a.BodyPressed = true
+				a.BodyDragging = a.bodyAt(x, y)
+				if !a.BodyDragging {
+					body := bodyShapes[a.BodyShapeIdx].New(a.BodySize, a.BodyDensity)
+					a.Sim.CmdChan <- simulation.Command{Kind: simulation.CmdAddBody, X: x, Y: y, Body: &body}
+				}
+			}
+			if a.BodyDragging {
+				a.Sim.CmdChan <- simulation.Command{Kind: simulation.CmdDragBody, X: x, Y: y, On: true}
+			}
+		}
+	}
+}
+
+// bodyShapes are the bodies the body tool places, size is the width in simulation cells
+var bodyShapes = []struct {
+	Name string
+	New  func(size, density float64) simulation.Body
+}{
+	{"Box", func(size, density float64) simulation.Body {
+		return simulation.NewBox(0, 0, size, size/2, density)
+	}},
+	{"Circle", func(size, density float64) simulation.Body {
+		return simulation.NewCircle(0, 0, size/2, density)
+	}},
+	{"Triangle", func(size, density float64) simulation.Body {
+		h := size / 2
+		return simulation.NewPolygon(0, 0, []simulation.Vector{{X: -h, Y: h / 2}, {X: h, Y: h / 2}, {X: 0, Y: -h / 2}}, density)
+	}},
+	{"Boat", func(size, density float64) simulation.Body {
+		h := size / 2
+		return simulation.NewPolygon(0, 0, []simulation.Vector{{X: -h, Y: -h / 3}, {X: h, Y: -h / 3}, {X: h * 0.6, Y: h / 3}, {X: -h * 0.6, Y: h / 3}}, density)
+	}},
+}
+
+// bodyAt reports whether a body of the last frame covers x, y
+func (a *App) bodyAt(x, y float64) bool {
+	for i := range a.CurrentBodies {
+		if a.CurrentBodies[i].Contains(x, y) {
+			return true
 		}
 	}
+	return false
+}
+
+func (a *App) IsForceMode() bool {
+	return a.MouseMode == ModeAttract || a.MouseMode == ModeRepel || a.MouseMode == ModeDrag
+}
+
+// SyncSeed keeps the command line seed when the config is replaced
+func (a *App) SyncSeed() {
+	if a.Seed != 0 {
+		a.UIConfig.Seed = a.Seed
+	}
 }
 
 func (a *App) SyncPalette() {
@@ -209,18 +555,139 @@ func (a *App) SyncPalette() {
 	}
 }
 
+// SyncSpawnSpecies selects the spawn species the preset asks for
+func (a *App) SyncSpawnSpecies() {
+	if a.UIConfig.SpawnSpecies == "" {
+		return
+	}
+	for i, sp := range a.Species {
+		if sp.Name == a.UIConfig.SpawnSpecies {
+			a.SpawnSpecies = i
+			return
+		}
+	}
+}
+
+func (a *App) SyncRenderMode() {
+	a.UIConfig.RenderModeIdx = render.ModeIndex(a.UIConfig.RenderMode)
+}
+
+// ApplyRenderMode switches the simulation domain to the resolution of the selected render mode
+func (a *App) ApplyRenderMode() {
+	mode := render.Modes[a.UIConfig.RenderModeIdx]
+	a.UIConfig.RenderMode = mode.Name
+	if mode.Name == a.Mode.Name {
+		return
+	}
+
+	a.CursorX = a.CursorX * float64(mode.ScaleX) / float64(a.Mode.ScaleX)
+	a.CursorY = a.CursorY * float64(mode.ScaleY) / float64(a.Mode.ScaleY)
+	a.Mode = mode
+
+	a.SimW, a.SimH = a.ViewW*mode.ScaleX, a.ViewH*mode.ScaleY
+	a.Sim.CmdChan <- simulation.Command{Kind: simulation.CmdRescale, Width: a.SimW, Height: a.SimH}
+
+	a.AllocGrids()
+	a.ForceRedraw()
+}
+
+// UpdateColorRange sets the fixed range of the color mode or follows the values of
+// the current frame when auto range is on
+func (a *App) UpdateColorRange() {
+	mode := render.ColorModes[a.ColorModeIdx]
+	if mode.Value == nil {
+		return
+	}
+
+	if !a.AutoRange {
+		a.RangeMin, a.RangeMax = mode.Min, mode.Max
+		if r, ok := a.AppConfig.ColorRanges[mode.Name]; ok {
+			a.RangeMin, a.RangeMax = r[0], r[1]
+		}
+		a.RangeValid = false
+		return
+	}
+
+	if len(a.CurrentParticles) == 0 {
+		return
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for i := range a.CurrentParticles {
+		v := mode.Value(&a.CurrentParticles[i])
+		lo = math.Min(lo, v)
+		hi = math.Max(hi, v)
+	}
+
+	// smooth the range so the colors do not flicker between frames
+	if !a.RangeValid {
+		a.RangeMin, a.RangeMax = lo, hi
+		a.RangeValid = true
+	} else {
+		a.RangeMin += (lo - a.RangeMin) * 0.1
+		a.RangeMax += (hi - a.RangeMax) * 0.1
+	}
+}
+
+// cellOrigin returns the first simulation cell of the terminal cell containing x, y
+func (a *App) cellOrigin(x, y float64) (int, int) {
+	sx, sy := a.Mode.ScaleX, a.Mode.ScaleY
+	return int(x) / sx * sx, int(y) / sy * sy
+}
+
+func (a *App) SyncSpeciesPalettes() {
+	a.SpeciesPalettes = make([]int, len(a.Species))
+	for i, sp := range a.Species {
+		a.SpeciesPalettes[i] = -1
+		for j, p := range a.Palettes {
+			if p.Name == sp.PaletteName {
+				a.SpeciesPalettes[i] = j
+				break
+			}
+		}
+	}
+}
+
 func (a *App) InitMenu() {
 	a.MenuItems = []ui.MenuItem{
 		{Name: "Preset", Type: "preset_enum", Val: &a.ActivePresetIdx, Step: 1.0, Fmt: "%s"},
 		{Name: "Palette", Type: "enum", Val: &a.UIConfig.PaletteIdx, Step: 1.0, Fmt: "%s"},
+		{Name: "Fluid", Type: "species_enum", Val: &a.SpawnSpecies, Step: 1.0, Fmt: "%s"},
+		{Name: "Render", Type: "render_enum", Val: &a.UIConfig.RenderModeIdx, Step: 1.0, Fmt: "%s"},
+		{Name: "Color", Type: "color_enum", Val: &a.ColorModeIdx, Step: 1.0, Fmt: "%s"},
+		{Name: "AutoRange", Type: "toggle", Val: &a.AutoRange, Step: 1.0, Fmt: "%s"},
 		{Name: "SpawnQty", Type: "int", Val: &a.UIConfig.SpawnCount, Step: 5.0, Fmt: "%d"},
+		{Name: "EmitRate", Type: "tool", Val: &a.EmitRate, Step: 0.5, Fmt: "%.1f"},
+		{Name: "EmitAngle", Type: "tool", Val: &a.EmitAngle, Step: 15, Fmt: "%.0f"},
+		{Name: "EmitSpeed", Type: "tool", Val: &a.EmitSpeed, Step: 0.1, Fmt: "%.1f"},
+		{Name: "Radius", Type: "tool", Val: &a.ForceRadius, Step: 1, Fmt: "%.0f"},
+		{Name: "Strength", Type: "tool", Val: &a.ForceStrength, Step: 0.05, Fmt: "%.2f"},
+		{Name: "WallMat", Type: "wall_enum", Val: &a.WallMaterialIdx, Step: 1.0, Fmt: "%s"},
+		{Name: "WallTool", Type: "walltool_enum", Val: &a.WallToolIdx, Step: 1.0, Fmt: "%s"},
+		{Name: "Brush", Type: "tool", Val: &a.BrushSize, Step: 1, Fmt: "%.0f"},
+		{Name: "Body", Type: "body_enum", Val: &a.BodyShapeIdx, Step: 1.0, Fmt: "%s"},
+		{Name: "BodySize", Type: "tool", Val: &a.BodySize, Step: 1, Fmt: "%.0f"},
+		{Name: "BodyDens", Type: "tool", Val: &a.BodyDensity, Step: 0.1, Fmt: "%.1f"},
 		{Name: "Gravity", Type: "float", Val: &a.UIConfig.Gravity, Step: 0.01, Fmt: "%.2f"},
+		{Name: "GravAngle", Type: "float", Val: &a.UIConfig.GravityAngle, Step: 15, Fmt: "%.0f"},
+		{Name: "ZeroGrav", Type: "toggle", Val: &a.UIConfig.ZeroGravity, Step: 1.0, Fmt: "%s"},
 		{Name: "RestDens", Type: "float", Val: &a.UIConfig.RestDensity, Step: 0.5, Fmt: "%.1f"},
 		{Name: "Stiffness", Type: "float", Val: &a.UIConfig.Stiffness, Step: 0.01, Fmt: "%.2f"},
 		{Name: "StiffNear", Type: "float", Val: &a.UIConfig.StiffnessNear, Step: 0.01, Fmt: "%.2f"},
 		{Name: "Viscosity", Type: "float", Val: &a.UIConfig.Viscosity, Step: 0.001, Fmt: "%.3f"},
 		{Name: "Damping", Type: "float", Val: &a.UIConfig.Damping, Step: 0.005, Fmt: "%.3f"},
 		{Name: "InteractionRad", Type: "float", Val: &a.UIConfig.InteractionRad, Step: 0.2, Fmt: "%.1f"},
+		{Name: "Tension", Type: "float", Val: &a.UIConfig.SurfaceTension, Step: 0.005, Fmt: "%.3f"},
+		{Name: "Adhesion", Type: "float", Val: &a.UIConfig.Adhesion, Step: 0.005, Fmt: "%.3f"},
+		{Name: "WallFrict", Type: "float", Val: &a.UIConfig.WallFriction, Step: 0.05, Fmt: "%.2f"},
+		{Name: "WallBounce", Type: "float", Val: &a.UIConfig.WallRestitution, Step: 0.05, Fmt: "%.2f"},
+		{Name: "Springs", Type: "float", Val: &a.UIConfig.SpringStiffness, Step: 0.05, Fmt: "%.2f"},
+		{Name: "Yield", Type: "float", Val: &a.UIConfig.YieldRatio, Step: 0.05, Fmt: "%.2f"},
+		{Name: "Plasticity", Type: "float", Val: &a.UIConfig.Plasticity, Step: 0.05, Fmt: "%.2f"},
+		{Name: "Ambient", Type: "float", Val: &a.UIConfig.AmbientTemp, Step: 5, Fmt: "%.0f"},
+		{Name: "Conduct", Type: "float", Val: &a.UIConfig.Conductivity, Step: 0.02, Fmt: "%.2f"},
+		{Name: "Cooling", Type: "float", Val: &a.UIConfig.CoolingRate, Step: 0.001, Fmt: "%.3f"},
+		{Name: "Buoyancy", Type: "float", Val: &a.UIConfig.Buoyancy, Step: 0.0001, Fmt: "%.4f"},
+		{Name: "ThermVisc", Type: "float", Val: &a.UIConfig.ThermalViscosity, Step: 0.05, Fmt: "%.2f"},
 	}
 
 	if a.ConfigPath != "" {
@@ -232,16 +699,35 @@ func (a *App) Resize() {
 	a.Screen.Sync()
 	w, h := a.Screen.Size()
 
-	a.Sim.CmdChan <- func(s *simulation.Simulation) { s.Resize(w, h) }
+	a.ViewW, a.ViewH = simulation.DomainSize(w, h)
+	a.SimW, a.SimH = a.ViewW*a.Mode.ScaleX, a.ViewH*a.Mode.ScaleY
+	a.Sim.CmdChan <- simulation.Command{Kind: simulation.CmdResize, Width: a.SimW, Height: a.SimH}
+	// strokes and shapes refer to cells of the old view
+	a.stroking, a.shaping = false, false
+
+	a.AllocGrids()
+	a.Screen.Clear()
+}
 
-	a.SimW = w - simulation.SidebarWidth
-	a.SimH = h
+// AllocGrids sizes the grids for the current domain and view, keeping their memory when
+// it is large enough
+func (a *App) AllocGrids() {
+	a.CurrentGrid = fitGrid(a.CurrentGrid, a.SimW*a.SimH)
+	a.CurrentSpecies = fitGrid(a.CurrentSpecies, a.SimW*a.SimH)
+	a.FieldSum = fitGrid(a.FieldSum, a.SimW*a.SimH)
+	a.CurrentCover = fitGrid(a.CurrentCover, a.SimW*a.SimH)
+
+	a.LastCells = fitGrid(a.LastCells, a.ViewW*a.ViewH)
+	a.Markers = fitGrid(a.Markers, a.ViewW*a.ViewH)
+	a.brushMask = fitGrid(a.brushMask, a.ViewW*a.ViewH)
+}
 
-	a.CurrentGrid = make([][]int, a.SimW)
-	a.LastGrid = make([][]int, a.SimW)
-	for i := range a.CurrentGrid {
-		a.CurrentGrid[i] = make([]int, a.SimH)
-		a.LastGrid[i] = make([]int, a.SimH)
+// fitGrid returns a cleared grid of n cells, reallocated only when it is too small
+func fitGrid[T any](grid []T, n int) []T {
+	if cap(grid) < n {
+		return make([]T, n)
 	}
-	a.Screen.Clear()
+	grid = grid[:n]
+	clear(grid)
+	return grid
 }
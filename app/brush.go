@@ -0,0 +1,310 @@
+package app
+
+import (
+	"math"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/simulation"
+)
+
+// Wall tools of the wall, erase, heat and cool modes. The shapes span from where the
+// mouse button is pressed to where it is released, fill acts on a click.
+const (
+	ToolFree = iota
+	ToolLine
+	ToolRect
+	ToolFilledRect
+	ToolCircle
+	ToolFill
+)
+
+var wallToolNames = []string{"Free", "Line", "Rect", "FillRect", "Circle", "Fill"}
+
+func (a *App) IsWallMode() bool {
+	return a.MouseMode == ModeWall || a.MouseMode == ModeErase || a.MouseMode == ModeHeat || a.MouseMode == ModeCool
+}
+
+// handleWallMouse draws with the wall tool, x, y is the terminal cell of the cursor in
+// the view. Freehand strokes connect the cells the cursor passed between two events.
+func (a *App) handleWallMouse(wasDown bool, x, y int) {
+	if !a.IsWallMode() {
+		a.stroking, a.shaping = false, false
+		return
+	}
+	pressed := a.IsMouseDown && !wasDown && a.MouseInBounds
+
+	switch a.WallToolIdx {
+	case ToolFree:
+		if !a.IsMouseDown || !a.MouseInBounds {
+			a.stroking = false
+			return
+		}
+		if a.stroking && x == a.strokeX && y == a.strokeY {
+			return
+		}
+		x0, y0 := x, y
+		if a.stroking {
+			x0, y0 = a.strokeX, a.strokeY
+		}
+		a.markLine(x0, y0, x, y)
+		a.strokeX, a.strokeY, a.stroking = x, y, true
+		a.sendMask()
+	case ToolFill:
+		if pressed {
+			a.markFill(x, y)
+			a.sendMask()
+		}
+	default:
+		if pressed {
+			a.shapeX, a.shapeY, a.shaping = x, y, true
+		}
+		if !a.shaping {
+			return
+		}
+		a.shapeEndX = min(max(x, 0), a.ViewW-1)
+		a.shapeEndY = min(max(y, 0), a.ViewH-1)
+		if !a.IsMouseDown {
+			a.shaping = false
+			a.markShape()
+			a.sendMask()
+		}
+	}
+}
+
+// brushCells is the brush size in terminal cells
+func (a *App) brushCells() int {
+	return max(int(a.BrushSize), 1)
+}
+
+// markBrush marks the square brush centered on the terminal cell x, y
+func (a *App) markBrush(x, y int) {
+	size := a.brushCells()
+	for j := y - (size-1)/2; j <= y+size/2; j++ {
+		for i := x - (size-1)/2; i <= x+size/2; i++ {
+			if i >= 0 && i < a.ViewW && j >= 0 && j < a.ViewH {
+				a.brushMask[i+j*a.ViewW] = true
+			}
+		}
+	}
+}
+
+// markLine stamps the brush along the cells of a Bresenham line
+func (a *App) markLine(x0, y0, x1, y1 int) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	stepX, stepY := 1, 1
+	if x1 < x0 {
+		stepX = -1
+	}
+	if y1 < y0 {
+		stepY = -1
+	}
+	e := dx + dy
+	for {
+		a.markBrush(x0, y0)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += stepX
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += stepY
+		}
+	}
+}
+
+// markShape marks the shape of the wall tool between the anchor and the end cell
+func (a *App) markShape() {
+	x0, y0, x1, y1 := a.shapeX, a.shapeY, a.shapeEndX, a.shapeEndY
+	switch a.WallToolIdx {
+	case ToolLine:
+		a.markLine(x0, y0, x1, y1)
+	case ToolRect:
+		a.markLine(x0, y0, x1, y0)
+		a.markLine(x1, y0, x1, y1)
+		a.markLine(x1, y1, x0, y1)
+		a.markLine(x0, y1, x0, y0)
+	case ToolFilledRect:
+		for y := min(y0, y1); y <= max(y0, y1); y++ {
+			for x := min(x0, x1); x <= max(x0, x1); x++ {
+				a.brushMask[x+y*a.ViewW] = true
+			}
+		}
+	case ToolCircle:
+		a.markCircle(x0, y0, x1, y1)
+	}
+}
+
+// markCircle stamps the brush along a circle around x0, y0 through x1, y1. The radius
+// is measured in simulation cells, like the force brush, so the circle stays round in
+// the render modes with square sub-cells.
+func (a *App) markCircle(x0, y0, x1, y1 int) {
+	sx, sy := float64(a.Mode.ScaleX), float64(a.Mode.ScaleY)
+	r := math.Hypot(float64(x1-x0)*sx, float64(y1-y0)*sy)
+	// half a cell diagonal keeps the outline connected
+	band := math.Hypot(sx, sy) * 0.5
+	rx, ry := int(math.Ceil((r+band)/sx)), int(math.Ceil((r+band)/sy))
+	for y := max(y0-ry, 0); y <= min(y0+ry, a.ViewH-1); y++ {
+		for x := max(x0-rx, 0); x <= min(x0+rx, a.ViewW-1); x++ {
+			d := math.Hypot(float64(x-x0)*sx, float64(y-y0)*sy)
+			if math.Abs(d-r) < band {
+				a.markBrush(x, y)
+			}
+		}
+	}
+}
+
+// markFill marks the region of the terminal cell x, y: the connected cells that are
+// all wall or all open like it. Open regions fill with walls, wall regions are erased,
+// heated, cooled or given the current material as a whole.
+func (a *App) markFill(x, y int) {
+	walls := a.walls()
+	if walls == nil {
+		return
+	}
+	sx, sy := a.Mode.ScaleX, a.Mode.ScaleY
+	// a terminal cell counts as wall when any of its sub-cells is one
+	solid := func(idx int) bool {
+		cx, cy := idx%a.ViewW*sx, idx/a.ViewW*sy
+		for j := cy; j < min(cy+sy, a.SimH); j++ {
+			for i := cx; i < min(cx+sx, a.SimW); i++ {
+				if walls.Solid[i+j*a.SimW] {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	start := x + y*a.ViewW
+	target := solid(start)
+	if a.MouseMode == ModeErase && !target {
+		return
+	}
+	a.brushMask[start] = true
+	a.fillQueue = append(a.fillQueue[:0], start)
+	for k := 0; k < len(a.fillQueue); k++ {
+		idx := a.fillQueue[k]
+		cx, cy := idx%a.ViewW, idx/a.ViewW
+		for _, n := range [4][2]int{{cx - 1, cy}, {cx + 1, cy}, {cx, cy - 1}, {cx, cy + 1}} {
+			if n[0] < 0 || n[0] >= a.ViewW || n[1] < 0 || n[1] >= a.ViewH {
+				continue
+			}
+			next := n[0] + n[1]*a.ViewW
+			if !a.brushMask[next] && solid(next) == target {
+				a.brushMask[next] = true
+				a.fillQueue = append(a.fillQueue, next)
+			}
+		}
+	}
+}
+
+// sendMask sends the marked cells as one command of wall blocks and clears the mask.
+// Each block is a run of marked cells in one row, extended down over the rows where the
+// run is marked too.
+func (a *App) sendMask() {
+	sx, sy := a.Mode.ScaleX, a.Mode.ScaleY
+	// the command keeps the blocks until the simulation applied it
+	var blocks []simulation.WallBlock
+	for y := 0; y < a.ViewH; y++ {
+		row := a.brushMask[y*a.ViewW : (y+1)*a.ViewW]
+		for x := 0; x < a.ViewW; x++ {
+			if !row[x] {
+				continue
+			}
+			end := x + 1
+			for end < a.ViewW && row[end] {
+				end++
+			}
+			height := 1
+			for y+height < a.ViewH && a.rowMarked(x, end, y+height) {
+				height++
+			}
+			for j := y; j < y+height; j++ {
+				clear(a.brushMask[x+j*a.ViewW : end+j*a.ViewW])
+			}
+
+			blocks = append(blocks, simulation.WallBlock{X: x * sx, Y: y * sy, Width: (end - x) * sx, Height: height * sy})
+			x = end - 1
+		}
+	}
+	if len(blocks) == 0 {
+		return
+	}
+
+	cmd := a.wallCommand()
+	cmd.X, cmd.Y = float64(blocks[0].X), float64(blocks[0].Y)
+	cmd.Width, cmd.Height = blocks[0].Width, blocks[0].Height
+	if len(blocks) > 1 {
+		cmd.Blocks = blocks[1:]
+	}
+	a.Sim.CmdChan <- cmd
+}
+
+// rowMarked reports whether the cells x0 up to x1 of row y are all marked
+func (a *App) rowMarked(x0, x1, y int) bool {
+	for _, marked := range a.brushMask[x0+y*a.ViewW : x1+y*a.ViewW] {
+		if !marked {
+			return false
+		}
+	}
+	return true
+}
+
+// wallCommand is the wall block the current mode draws, without its position and size
+func (a *App) wallCommand() simulation.Command {
+	heat := simulation.HeatNone
+	if a.MouseMode == ModeHeat {
+		heat = simulation.HeatHot
+	} else if a.MouseMode == ModeCool {
+		heat = simulation.HeatCold
+	}
+	cmd := simulation.Command{
+		Kind: simulation.CmdSetWall,
+		On:   a.MouseMode != ModeErase,
+		Heat: heat,
+	}
+	if a.MouseMode == ModeWall {
+		cmd.Material = simulation.WallMaterial(a.WallMaterialIdx)
+	}
+	return cmd
+}
+
+// markPreview marks the shape being drawn, or the brush at the cursor when it covers
+// more than one cell, and reports whether anything was marked
+func (a *App) markPreview() bool {
+	if !a.IsWallMode() {
+		return false
+	}
+	if a.shaping {
+		a.markShape()
+		return true
+	}
+	if a.MouseInBounds && a.brushCells() > 1 && a.WallToolIdx != ToolFilledRect && a.WallToolIdx != ToolFill {
+		a.markBrush(int(a.CursorX)/a.Mode.ScaleX, int(a.CursorY)/a.Mode.ScaleY)
+		return true
+	}
+	return false
+}
+
+// spawnCommand spawns fluid over the brush at the cursor
+func (a *App) spawnCommand() simulation.Command {
+	size := a.brushCells()
+	return simulation.Command{
+		Kind:    simulation.CmdSpawn,
+		X:       a.CursorX,
+		Y:       a.CursorY,
+		Width:   size * a.Mode.ScaleX,
+		Height:  size * a.Mode.ScaleY,
+		Species: a.SpawnSpecies,
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
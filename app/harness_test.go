@@ -0,0 +1,215 @@
+package app
+
+import (
+	"encoding/json"
+	"flag"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/null-enjoyer/terminal-fluid-simulation/simulation"
+)
+
+var benchFrames = flag.Int("harness.bench", 0, "run TestHarnessBench for this many frames")
+
+// TestHarnessRace drives the app while the solver runs on several workers and fills the
+// rewind buffer, run it with go test -race so concurrent access between the app, the
+// simulation and the workers fails
+func TestHarnessRace(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	frames := 300
+	if testing.Short() {
+		frames = 100
+	}
+	report := runHarness(Options{Workers: 4, RewindFrames: 60}, frames, false)
+	if report.Frames != frames {
+		t.Errorf("frames = %d, want %d", report.Frames, frames)
+	}
+	if report.Particles == 0 {
+		t.Error("no particles were spawned")
+	}
+}
+
+// TestHarnessBench pours fluid for the first third of the run and then measures the
+// steady frame pipeline, it only runs with -harness.bench set to the number of frames
+func TestHarnessBench(t *testing.T) {
+	if *benchFrames <= 0 {
+		t.Skip("set -harness.bench to the number of frames")
+	}
+	report := runHarness(Options{}, *benchFrames, true)
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%s", out)
+}
+
+// harnessSizes are the terminal sizes the harness switches between
+var harnessSizes = [][2]int{{120, 40}, {96, 30}, {140, 45}}
+
+// harnessReport is the last frame and the frame times of a harness run
+type harnessReport struct {
+	Frames      int    `json:"frames"`
+	Particles   int    `json:"particles"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	WallCells   int    `json:"wall_cells"`
+	WallVersion uint64 `json:"wall_version"`
+
+	// measured over the rendered frames after the first third of the run
+	RenderFrames   int     `json:"render_frames"`
+	MeanRenderTime float64 `json:"mean_render_us"`
+	P95RenderTime  int64   `json:"p95_render_us"`
+	MaxRenderTime  int64   `json:"max_render_us"`
+	MeanCalcTime   float64 `json:"mean_calc_us"`
+	AllocsPerFrame float64 `json:"allocs_per_frame"` // heap allocations of all goroutines
+	BytesPerFrame  float64 `json:"bytes_per_frame"`
+	GCCycles       uint32  `json:"gc_cycles"`
+}
+
+// benchScreen does not draw the virtual screen, which allocates for every changed cell
+// unlike a terminal and would hide the allocations of the frame pipeline
+type benchScreen struct {
+	tcell.SimulationScreen
+}
+
+func (benchScreen) Show() {}
+
+// runHarness drives the interactive app on a virtual screen for the given number of
+// ticks. The simulation runs in its own goroutine like in a terminal, while the input
+// paints and clears walls with each wall tool, spawns, cycles through the tools, tweaks
+// the menu, changes the time scale, rewinds and steps frames and resizes the screen.
+// Built with the race detector this checks that the app only sees the simulation through
+// its frames. With bench set the input only pours fluid for the first third of the run
+// and the rest measures the steady frame pipeline, without drawing the virtual screen.
+// It reports the last frame and the frame times.
+func runHarness(opts Options, frames int, bench bool) harnessReport {
+	screen := tcell.NewSimulationScreen("")
+	opts.Screen = screen
+	if bench {
+		opts.Screen = benchScreen{screen}
+	}
+	a := New(opts)
+	defer a.Screen.Fini()
+
+	warmup := frames / 3
+	a.stats = &frameStats{
+		warmup:  max(warmup, 1),
+		renders: make([]time.Duration, 0, frames*2),
+		phys:    make([]time.Duration, 0, frames*2),
+	}
+	screen.SetSize(harnessSizes[0][0], harnessSizes[0][1])
+	screen.PostEvent(tcell.NewEventResize(harnessSizes[0][0], harnessSizes[0][1]))
+
+	go func() {
+		for frame := 0; frame < frames; frame++ {
+			time.Sleep(a.FrameInterval)
+			if !bench {
+				driveFrame(screen, frame)
+			} else if frame < warmup {
+				pourFrame(screen, frame)
+			} else if frame == warmup {
+				screen.InjectMouse(0, 0, tcell.ButtonNone, tcell.ModNone)
+			}
+		}
+		screen.InjectKey(tcell.KeyEscape, 0, tcell.ModNone)
+	}()
+	a.Run()
+
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+
+	report := harnessReport{
+		Frames:    frames,
+		Particles: len(a.CurrentParticles),
+		Width:     a.SimW,
+		Height:    a.SimH,
+	}
+	if walls := a.walls(); walls != nil {
+		report.WallVersion = walls.Version
+		for _, wall := range walls.Solid {
+			if wall {
+				report.WallCells++
+			}
+		}
+	}
+
+	st := a.stats
+	if measured := st.renders[min(st.warmup, len(st.renders)):]; len(measured) > 0 {
+		total := time.Duration(0)
+		for _, d := range measured {
+			total += d
+		}
+		report.RenderFrames = len(measured)
+		report.MeanRenderTime = float64(total.Microseconds()) / float64(len(measured))
+		sorted := slices.Sorted(slices.Values(measured))
+		report.P95RenderTime = sorted[len(sorted)*95/100].Microseconds()
+		report.MaxRenderTime = sorted[len(sorted)-1].Microseconds()
+		report.AllocsPerFrame = float64(after.Mallocs-st.before.Mallocs) / float64(len(measured))
+		report.BytesPerFrame = float64(after.TotalAlloc-st.before.TotalAlloc) / float64(len(measured))
+		report.GCCycles = after.NumGC - st.before.NumGC
+	}
+	if len(st.phys) > 0 {
+		total := time.Duration(0)
+		for _, d := range st.phys {
+			total += d
+		}
+		report.MeanCalcTime = float64(total.Microseconds()) / float64(len(st.phys))
+	}
+
+	return report
+}
+
+// driveFrame sends the input of one tick, the mouse sweeps over the domain with the
+// button held most of the time
+func driveFrame(screen tcell.SimulationScreen, frame int) {
+	w, h := screen.Size()
+	switch {
+	case frame%90 == 89:
+		size := harnessSizes[(frame/90)%len(harnessSizes)]
+		screen.SetSize(size[0], size[1])
+		screen.PostEvent(tcell.NewEventResize(size[0], size[1]))
+	case frame%120 == 60:
+		screen.InjectKey(tcell.KeyRune, 'c', tcell.ModNone)
+	case frame%30 == 0:
+		screen.InjectKey(tcell.KeyTab, 0, tcell.ModNone)
+	case frame%50 == 25:
+		screen.InjectKey(tcell.KeyRune, ' ', tcell.ModNone)
+	case frame%100 == 10:
+		screen.InjectKey(tcell.KeyRune, 'd', tcell.ModNone)
+	case frame%140 == 40:
+		screen.InjectKey(tcell.KeyRune, '+', tcell.ModNone)
+	case frame%140 == 100:
+		screen.InjectKey(tcell.KeyRune, '-', tcell.ModNone)
+	case frame%160 == 120:
+		screen.InjectKey(tcell.KeyRune, '<', tcell.ModNone)
+	case frame%160 == 125:
+		screen.InjectKey(tcell.KeyRune, '/', tcell.ModNone)
+	case frame%160 == 130:
+		screen.InjectKey(tcell.KeyRune, '.', tcell.ModNone)
+	case frame%160 == 135:
+		screen.InjectKey(tcell.KeyRune, 'p', tcell.ModNone)
+	case frame%110 == 55:
+		screen.InjectKey(tcell.KeyRune, 'b', tcell.ModNone)
+	}
+
+	viewW := max(w-simulation.SidebarWidth, 1)
+	x := simulation.SidebarWidth + (frame*3)%viewW
+	y := 2 + (frame/7)%max(h-4, 1)
+	buttons := tcell.ButtonNone
+	if frame%10 < 7 {
+		buttons = tcell.Button1
+	}
+	screen.InjectMouse(x, y, buttons, tcell.ModNone)
+}
+
+// pourFrame spawns fluid along the top of the domain
+func pourFrame(screen tcell.SimulationScreen, frame int) {
+	w, _ := screen.Size()
+	viewW := max(w-simulation.SidebarWidth-4, 1)
+	x := simulation.SidebarWidth + 2 + (frame*5)%viewW
+	screen.InjectMouse(x, 2, tcell.Button1, tcell.ModNone)
+}
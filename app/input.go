@@ -3,58 +3,61 @@ package app
 import (
 	"github.com/gdamore/tcell/v2"
 	"github.com/null-enjoyer/terminal-fluid-simulation/config"
+	"github.com/null-enjoyer/terminal-fluid-simulation/render"
 	"github.com/null-enjoyer/terminal-fluid-simulation/simulation"
 	"log"
+	"math"
 )
 
 func (a *App) HandleMouse(ev *tcell.EventMouse) {
 	x, y := ev.Position()
 	btn := ev.Buttons()
 
-	simX := x - simulation.SidebarWidth
-	simY := y
+	cellX := x - simulation.SidebarWidth
+	cellY := y
 
-	if simX >= 0 && simX < a.SimW && simY >= 0 && simY < a.SimH {
-		a.CursorX = float64(simX)
-		a.CursorY = float64(simY)
+	if cellX >= 0 && cellX < a.ViewW && cellY >= 0 && cellY < a.ViewH {
+		// center of the terminal cell in simulation cells
+		sx, sy := a.Mode.ScaleX, a.Mode.ScaleY
+		a.CursorX = float64(cellX*sx) + float64(sx-1)/2
+		a.CursorY = float64(cellY*sy) + float64(sy-1)/2
 		a.MouseInBounds = true
 	} else {
 		a.MouseInBounds = false
 	}
 
+	wasDown := a.IsMouseDown
 	if btn&tcell.Button1 != 0 {
 		a.IsMouseDown = true
 	} else {
 		a.IsMouseDown = false
 	}
+	// walls are drawn as the mouse moves, so fast strokes leave no gaps between ticks
+	a.handleWallMouse(wasDown, cellX, cellY)
 }
 
 func (a *App) HandleInput(ev *tcell.EventKey) bool {
 	if a.InputMode {
 		switch ev.Key() {
 		case tcell.KeyEnter:
-			name := a.InputText
-			if name == "" {
-				name = "Custom"
-			}
-
-			a.UIConfig.PaletteName = a.Palettes[a.UIConfig.PaletteIdx].Name
-			a.AppConfig.Presets[name] = a.UIConfig
-
-			if err := config.SaveSettings(a.ConfigPath, a.AppConfig); err != nil {
-				log.Fatalf("Error saving settings (%s): %v", a.ConfigPath, err)
-			}
-
-			a.PresetNames = config.GetSortedPresetNames(a.AppConfig.Presets)
-			a.ActivePresetName = name
-
-			for i, n := range a.PresetNames {
-				if n == a.ActivePresetName {
-					a.ActivePresetIdx = i
-					break
+			switch a.InputAction {
+			case InputSavePreset:
+				a.savePreset(a.InputText)
+			case InputSaveScene, InputLoadScene:
+				path := a.InputText
+				if path == "" {
+					path = DefaultScenePath
+				}
+				var err error
+				if a.InputAction == InputSaveScene {
+					err = a.SaveScene(path)
+				} else {
+					err = a.LoadScene(path)
+				}
+				if err != nil {
+					a.StatusMsg = err.Error()
 				}
 			}
-
 			a.ForceRedraw()
 			a.InputMode = false
 			a.InputText = ""
@@ -79,19 +82,24 @@ func (a *App) HandleInput(ev *tcell.EventKey) bool {
 	case tcell.KeyEnter:
 		item := a.MenuItems[a.SelectedItem]
 		if item.Type == "action" && item.Name == "Save" {
-			a.InputMode = true
-			a.InputText = ""
+			a.startInput(InputSavePreset, "")
 		}
+	case tcell.KeyCtrlS:
+		a.startInput(InputSaveScene, a.ScenePath)
+	case tcell.KeyCtrlO:
+		a.startInput(InputLoadScene, a.ScenePath)
+	case tcell.KeyCtrlR:
+		a.ToggleRecording()
 	case tcell.KeyTab:
 		a.cycleMouseMode()
 	case tcell.KeyLeft:
-		a.CursorX -= 2.0
+		a.CursorX -= 2.0 * float64(a.Mode.ScaleX)
 	case tcell.KeyRight:
-		a.CursorX += 2.0
+		a.CursorX += 2.0 * float64(a.Mode.ScaleX)
 	case tcell.KeyUp:
-		a.CursorY -= 1.0
+		a.CursorY -= float64(a.Mode.ScaleY)
 	case tcell.KeyDown:
-		a.CursorY += 1.0
+		a.CursorY += float64(a.Mode.ScaleY)
 	case tcell.KeyRune:
 		switch ev.Rune() {
 		case 'm', 'M':
@@ -99,21 +107,40 @@ func (a *App) HandleInput(ev *tcell.EventKey) bool {
 		case 'q':
 			return true
 		case 'r':
-			a.Sim.CmdChan <- func(s *simulation.Simulation) {
-				s.Particles = s.Particles[:0]
-			}
+			a.Sim.CmdChan <- simulation.Command{Kind: simulation.CmdClearParticles}
 			a.ForceRedraw()
 		case 'c', 'C':
-			a.Sim.CmdChan <- func(s *simulation.Simulation) {
-				for i := range s.Walls {
-					s.Walls[i] = false
-				}
-			}
+			a.Sim.CmdChan <- simulation.Command{Kind: simulation.CmdClearWalls}
 			a.ForceRedraw()
+		case '[', ']':
+			// tilt the tank
+			step := 15.0
+			if ev.Rune() == '[' {
+				step = -step
+			}
+			a.UIConfig.GravityAngle += step
+			a.sendConfig()
+		case 'g', 'G':
+			a.UIConfig.ZeroGravity = !a.UIConfig.ZeroGravity
+			a.sendConfig()
+		case '-', '_':
+			a.stepTimeScale(-1)
+		case '+', '=':
+			a.stepTimeScale(1)
+		case '.', '>':
+			// step forward one or ten frames, through the rewind buffer first
+			a.sendStep(simulation.Command{Kind: simulation.CmdStepFrame, Count: stepCount(ev.Rune() == '>')})
+		case ',', '<':
+			if a.RewindFrames <= 0 {
+				a.StatusMsg = "Rewind is off, start with --rewind 300"
+				break
+			}
+			a.sendStep(simulation.Command{Kind: simulation.CmdRewind, Count: stepCount(ev.Rune() == '<')})
+		case '/':
+			a.sendStep(simulation.Command{Kind: simulation.CmdStepSubstep})
 		case 'p', 'P':
 			a.UIConfig.IsPaused = !a.UIConfig.IsPaused
-			newCfg := a.UIConfig
-			a.Sim.CmdChan <- func(s *simulation.Simulation) { s.Config = newCfg }
+			a.Sim.CmdChan <- simulation.Command{Kind: simulation.CmdPause, On: a.UIConfig.IsPaused}
 		case 'w', 'W':
 			a.SelectedItem--
 			if a.SelectedItem < 0 {
@@ -129,8 +156,10 @@ func (a *App) HandleInput(ev *tcell.EventKey) bool {
 		case 'd', 'D':
 			a.handleTweak(1.0)
 		case ' ':
-			cx, cy := a.CursorX, a.CursorY
-			a.Sim.CmdChan <- func(s *simulation.Simulation) { s.Spawn(cx, cy) }
+			a.Sim.CmdChan <- a.spawnCommand()
+		case 'b', 'B':
+			a.WallToolIdx = (a.WallToolIdx + 1) % len(wallToolNames)
+			a.shaping = false
 		}
 	}
 
@@ -151,13 +180,75 @@ func (a *App) HandleInput(ev *tcell.EventKey) bool {
 	return false
 }
 
+func (a *App) startInput(action int, text string) {
+	a.InputMode = true
+	a.InputAction = action
+	a.InputText = text
+}
+
+func (a *App) savePreset(name string) {
+	if name == "" {
+		name = "Custom"
+	}
+
+	a.UIConfig.PaletteName = a.Palettes[a.UIConfig.PaletteIdx].Name
+	a.UIConfig.RenderMode = a.Mode.Name
+	a.AppConfig.Presets[name] = a.UIConfig
+
+	if err := config.SaveSettings(a.ConfigPath, a.AppConfig); err != nil {
+		log.Fatalf("Error saving settings (%s): %v", a.ConfigPath, err)
+	}
+
+	a.PresetNames = config.GetSortedPresetNames(a.AppConfig.Presets)
+	a.ActivePresetName = name
+
+	for i, n := range a.PresetNames {
+		if n == a.ActivePresetName {
+			a.ActivePresetIdx = i
+			break
+		}
+	}
+}
+
 func (a *App) cycleMouseMode() {
 	a.MouseMode++
-	if a.MouseMode > ModeErase {
+	if a.MouseMode > ModeBody {
 		a.MouseMode = ModeSpawn
 	}
 }
 
+// sendStep sends a frame step or rewind, which pause the simulation
+func (a *App) sendStep(cmd simulation.Command) {
+	a.UIConfig.IsPaused = true
+	a.Sim.CmdChan <- cmd
+}
+
+// stepCount is the number of frames a step or rewind key moves, more with shift
+func stepCount(shift bool) int {
+	if shift {
+		return 10
+	}
+	return 1
+}
+
+// stepTimeScale switches to the next slower or faster entry of TimeScales
+func (a *App) stepTimeScale(dir int) {
+	scales := simulation.TimeScales
+	i := 0
+	for i < len(scales)-1 && scales[i] < a.TimeScale {
+		i++
+	}
+	if dir < 0 {
+		i--
+	} else if scales[i] <= a.TimeScale {
+		i++
+	}
+	i = min(max(i, 0), len(scales)-1)
+
+	a.TimeScale = scales[i]
+	a.Sim.CmdChan <- simulation.Command{Kind: simulation.CmdTimeScale, Scale: a.TimeScale}
+}
+
 func (a *App) handleTweak(delta float64) {
 	item := a.MenuItems[a.SelectedItem]
 	isCustomizing := false
@@ -175,7 +266,11 @@ func (a *App) handleTweak(delta float64) {
 
 		newP := a.AppConfig.Presets[a.ActivePresetName]
 		a.UIConfig = newP
+		a.SyncSeed()
 		a.SyncPalette()
+		a.SyncSpawnSpecies()
+		a.SyncRenderMode()
+		a.ApplyRenderMode()
 		a.InitMenu()
 		a.ForceRedraw()
 
@@ -183,6 +278,17 @@ func (a *App) handleTweak(delta float64) {
 		val := item.Val.(*float64)
 		*val += delta * item.Step
 		isCustomizing = true
+	case "tool":
+		// tool settings are not part of the preset
+		val := item.Val.(*float64)
+		*val += delta * item.Step
+		if val == &a.EmitAngle {
+			*val = math.Mod(*val+360, 360)
+		} else if val == &a.BrushSize {
+			*val = max(*val, 1)
+		} else if *val < 0 {
+			*val = 0
+		}
 	case "int":
 		val := item.Val.(*int)
 		*val += int(delta * item.Step)
@@ -201,13 +307,80 @@ func (a *App) handleTweak(delta float64) {
 		}
 		isCustomizing = true
 		a.ForceRedraw()
+	case "species_enum":
+		val := item.Val.(*int)
+		*val += int(delta)
+		if *val < 0 {
+			*val = len(a.Species) - 1
+		}
+		if *val >= len(a.Species) {
+			*val = 0
+		}
+	case "wall_enum":
+		val := item.Val.(*int)
+		*val += int(delta)
+		if *val < 0 {
+			*val = len(simulation.WallMaterialNames) - 1
+		}
+		if *val >= len(simulation.WallMaterialNames) {
+			*val = 0
+		}
+	case "walltool_enum":
+		val := item.Val.(*int)
+		*val += int(delta)
+		if *val < 0 {
+			*val = len(wallToolNames) - 1
+		}
+		if *val >= len(wallToolNames) {
+			*val = 0
+		}
+		a.shaping = false
+	case "body_enum":
+		val := item.Val.(*int)
+		*val += int(delta)
+		if *val < 0 {
+			*val = len(bodyShapes) - 1
+		}
+		if *val >= len(bodyShapes) {
+			*val = 0
+		}
+	case "render_enum":
+		val := item.Val.(*int)
+		*val += int(delta)
+		if *val < 0 {
+			*val = len(render.Modes) - 1
+		}
+		if *val >= len(render.Modes) {
+			*val = 0
+		}
+		a.ApplyRenderMode()
+		isCustomizing = true
+	case "color_enum":
+		val := item.Val.(*int)
+		*val += int(delta)
+		if *val < 0 {
+			*val = len(render.ColorModes) - 1
+		}
+		if *val >= len(render.ColorModes) {
+			*val = 0
+		}
+		a.RangeValid = false
+	case "toggle":
+		val := item.Val.(*bool)
+		*val = !*val
+		// zero gravity is part of the preset, the other toggles only change the display
+		isCustomizing = val == &a.UIConfig.ZeroGravity
 	}
 
 	if isCustomizing {
 		a.ActivePresetName = "Custom"
 	}
 
+	a.sendConfig()
+}
+
+func (a *App) sendConfig() {
 	a.UIConfig.UpdateDerived()
 	newCfg := a.UIConfig
-	a.Sim.CmdChan <- func(s *simulation.Simulation) { s.Config = newCfg }
+	a.Sim.CmdChan <- simulation.Command{Kind: simulation.CmdConfig, Config: &newCfg}
 }
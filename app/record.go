@@ -0,0 +1,96 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/replay"
+	"github.com/null-enjoyer/terminal-fluid-simulation/simulation"
+)
+
+const DefaultRecordPath = "recording.jsonl"
+
+func (a *App) ToggleRecording() {
+	if a.Recorder != nil {
+		a.StopRecording()
+		return
+	}
+
+	path := a.RecordPath
+	if path == "" {
+		path = DefaultRecordPath
+	}
+	if err := a.StartRecording(path); err != nil {
+		a.StatusMsg = err.Error()
+	}
+}
+
+func (a *App) StartRecording(path string) error {
+	w, err := replay.Create(path)
+	if err != nil {
+		return err
+	}
+
+	seed := a.Seed
+	done := make(chan error, 1)
+	a.Sim.ControlChan <- func(s *simulation.Simulation) { done <- w.Start(s, seed) }
+	if err := <-done; err != nil {
+		w.Close()
+		return err
+	}
+
+	a.Recorder = w
+	a.RecordPath = path
+	a.StatusMsg = fmt.Sprintf("Recording to %s", path)
+	return nil
+}
+
+func (a *App) StopRecording() {
+	if a.Recorder == nil {
+		return
+	}
+
+	w := a.Recorder
+	done := make(chan error, 1)
+	a.Sim.ControlChan <- func(s *simulation.Simulation) {
+		err := s.StopRecording()
+		if err != nil {
+			err = fmt.Errorf("recording stopped: %w", err)
+		}
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		done <- err
+	}
+	if err := <-done; err != nil {
+		a.StatusMsg = err.Error()
+	} else {
+		a.StatusMsg = fmt.Sprintf("Saved %s", a.RecordPath)
+	}
+	a.Recorder = nil
+}
+
+// startReplay runs before the simulation goroutine starts, live input is ignored until
+// the recording ends
+func (a *App) startReplay(path string) error {
+	rec, err := replay.Load(path)
+	if err != nil {
+		return err
+	}
+
+	a.UIConfig = rec.Header.Config
+	a.ActivePresetName = "Replay"
+	a.SyncPalette()
+	a.SyncRenderMode()
+	a.ApplyRenderMode()
+	a.InitMenu()
+
+	rec.Start(a.Sim)
+	if len(rec.Header.Species) > 0 {
+		a.Species = rec.Header.Species
+		a.SyncSpeciesPalettes()
+	}
+	a.SimW, a.SimH = rec.Header.State.Width, rec.Header.State.Height
+	a.AllocGrids()
+	a.StatusMsg = fmt.Sprintf("Replaying %s", path)
+	return nil
+}
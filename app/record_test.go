@@ -0,0 +1,47 @@
+package app
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/null-enjoyer/terminal-fluid-simulation/simulation"
+)
+
+// TestRecordErrorReported records to a device that is always full, the failed write has
+// to end the recording with the error in the status line
+func TestRecordErrorReported(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full")
+	}
+	screen := tcell.NewSimulationScreen("")
+	a := New(Options{Screen: screen})
+	defer a.Screen.Fini()
+	go a.Sim.Run()
+	defer a.Sim.Close()
+
+	if err := a.StartRecording("/dev/full"); err != nil {
+		t.Fatal(err)
+	}
+	// enough commands to fill the write buffer
+	go func() {
+		for range 500 {
+			a.Sim.CmdChan <- simulation.Command{Kind: simulation.CmdSetWall, X: 2, Y: 2, Width: 1, Height: 1}
+		}
+	}()
+
+	deadline := time.After(10 * time.Second)
+	for a.Recorder != nil {
+		select {
+		case f := <-a.Sim.RenderChan:
+			a.applyFrame(f)
+		case <-deadline:
+			t.Fatal("recording still running after the write failed")
+		}
+	}
+	if !strings.HasPrefix(a.StatusMsg, "recording stopped:") {
+		t.Errorf("status %q, want the write error", a.StatusMsg)
+	}
+}
@@ -2,16 +2,43 @@ package app
 
 import (
 	"fmt"
+	"math"
+	"strconv"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gdamore/tcell/v2"
+	"github.com/null-enjoyer/terminal-fluid-simulation/render"
 	"github.com/null-enjoyer/terminal-fluid-simulation/simulation"
 	"github.com/null-enjoyer/terminal-fluid-simulation/ui"
 )
 
-func (a *App) Render() {
-	const wallID = 999
+// coverWall marks wall cells in CurrentCover, cells covered by a body hold its index + 1
+const coverWall = -1
+
+// menuControls are the key bindings listed below the menu
+var menuControls = []string{
+	" [Tab] Mode  [B] Wall Tool",
+	" [Mouse LB] Spawn/Draw/Erase",
+	" [ C ] Clear Walls/Objects",
+	" [Space] Spawn Fluid",
+	" [ P ] Pause",
+	" [ R ] Clear Fluid",
+	" [[/]] Tilt  [G] Zero Gravity",
+	" [^S/^O] Save/Load Scene",
+	" [^R] Start/Stop Recording",
+	" [,/.] Rewind/Fwd [/] Substep",
+}
+
+// footerLine is a sidebar line below the menu, its text spans start to end of
+// App.footerText. Legend lines draw the color legend instead of text.
+type footerLine struct {
+	start, end int
+	style      tcell.Style
+	legend     bool
+}
 
+func (a *App) Render() {
 	renderStart := time.Now()
 	a.FpsCounter++
 	if time.Since(a.FpsTimer) >= time.Second {
@@ -20,90 +47,177 @@ func (a *App) Render() {
 		a.FpsTimer = time.Now()
 	}
 
+	sx, sy := a.Mode.ScaleX, a.Mode.ScaleY
+
 	// reset grid
-	for x := 0; x < a.SimW; x++ {
-		for y := 0; y < a.SimH; y++ {
-			a.CurrentGrid[x][y] = 0
+	clear(a.CurrentGrid)
+	clear(a.CurrentSpecies)
+	clear(a.FieldSum)
+	clear(a.CurrentCover)
+
+	// mark walls, a frame of another size is skipped until the grids caught up
+	if walls := a.walls(); walls != nil {
+		for i, wall := range walls.Solid {
+			if wall {
+				a.CurrentCover[i] = coverWall
+			}
 		}
 	}
 
-	// mark walls
-	wallLen := len(a.Sim.Walls)
-	if wallLen == a.SimW*a.SimH {
-		for x := 0; x < a.SimW; x++ {
-			for y := 0; y < a.SimH; y++ {
-				if a.Sim.Walls[x+y*a.SimW] {
-					a.CurrentGrid[x][y] = wallID
-				}
+	// mark sources on the terminal cells they cover
+	clear(a.Markers)
+	for _, src := range a.CurrentSources {
+		marker := sourceMarker(src)
+		for y := src.Y / sy; y <= (src.Y+src.Height-1)/sy && y < a.ViewH; y++ {
+			for x := src.X / sx; x <= (src.X+src.Width-1)/sx && x < a.ViewW; x++ {
+				a.Markers[x+y*a.ViewW] = marker
 			}
 		}
 	}
 
 	// add particles to grid
-	for _, p := range a.CurrentParticles {
+	colorMode := render.ColorModes[a.ColorModeIdx]
+	a.UpdateColorRange()
+	for i := range a.CurrentParticles {
+		p := &a.CurrentParticles[i]
 		if p.X >= 0 && p.X < a.SimW && p.Y >= 0 && p.Y < a.SimH {
-			if a.CurrentGrid[p.X][p.Y] != wallID {
-				a.CurrentGrid[p.X][p.Y] += 3
-				if p.X+1 < a.SimW && a.CurrentGrid[p.X+1][p.Y] != wallID {
-					a.CurrentGrid[p.X+1][p.Y] += 1
+			idx := p.X + p.Y*a.SimW
+			if a.CurrentCover[idx] != coverWall {
+				value := 0.0
+				if colorMode.Value != nil {
+					value = colorMode.Value(p)
+				}
+				// the particle centered in a cell decides its color, splats only color empty cells
+				a.CurrentGrid[idx] += 3
+				a.CurrentSpecies[idx] = p.Species
+				a.FieldSum[idx] += 3 * value
+				if p.X+1 < a.SimW && a.CurrentCover[idx+1] != coverWall {
+					a.splat(idx+1, p.Species, value)
 				}
-				if p.X-1 >= 0 && a.CurrentGrid[p.X-1][p.Y] != wallID {
-					a.CurrentGrid[p.X-1][p.Y] += 1
+				if p.X-1 >= 0 && a.CurrentCover[idx-1] != coverWall {
+					a.splat(idx-1, p.Species, value)
 				}
-				if p.Y+1 < a.SimH && a.CurrentGrid[p.X][p.Y+1] != wallID {
-					a.CurrentGrid[p.X][p.Y+1] += 1
+				if p.Y+1 < a.SimH && a.CurrentCover[idx+a.SimW] != coverWall {
+					a.splat(idx+a.SimW, p.Species, value)
 				}
 			}
 		}
 	}
 
-	palette := a.Palettes[a.UIConfig.PaletteIdx].Colors
-
-	for x := 0; x < a.SimW; x++ {
-		for y := 0; y < a.SimH; y++ {
-			newVal := a.CurrentGrid[x][y]
-			oldVal := a.LastGrid[x][y]
-
-			isCursor := int(a.CursorX) == x && int(a.CursorY) == y
-			wasCursor := a.LastCursorX == x && a.LastCursorY == y
+	// bodies are drawn over the fluid
+	for i := range a.CurrentBodies {
+		a.markBody(i)
+	}
 
-			if newVal != oldVal || isCursor || wasCursor {
-				screenX := x + simulation.SidebarWidth
-				screenY := y
+	// compose every terminal cell from its sub-cells and redraw the ones that changed
+	pixels := a.PixelBuf[:sx*sy]
+	cursorX, cursorY := int(a.CursorX)/sx, int(a.CursorY)/sy
+	showBrush := a.IsForceMode() && a.MouseInBounds
+	// the shape being drawn or the wall brush, cleared as the cells are drawn
+	preview := a.markPreview()
 
-				if isCursor {
-					cursorChar := '▼'
-					color := tcell.ColorRed
-					if a.MouseMode == ModeWall {
-						cursorChar = '■'
-						color = tcell.ColorGray
-					} else if a.MouseMode == ModeErase {
-						cursorChar = 'X'
-						color = tcell.ColorRed
-					}
-					a.Screen.SetContent(screenX, screenY, cursorChar, nil, tcell.StyleDefault.Foreground(color))
-				} else if newVal == 999 {
-					a.Screen.SetContent(screenX, screenY, '█', nil, tcell.StyleDefault.Foreground(tcell.ColorWhite))
-				} else if newVal > 0 {
-					idx := newVal / 2
-					if idx >= len(palette) {
-						idx = len(palette) - 1
+	for cy := 0; cy < a.ViewH; cy++ {
+		for cx := 0; cx < a.ViewW; cx++ {
+			idx := cx + cy*a.ViewW
+			var cell render.Cell
+			if cx == cursorX && cy == cursorY {
+				cell = a.cursorCell()
+			} else if a.Markers[idx].Rune != 0 {
+				cell = a.Markers[idx]
+			} else {
+				for j := 0; j < sy; j++ {
+					for i := 0; i < sx; i++ {
+						pixels[i+j*sx] = a.pixelColor(cx*sx+i, cy*sy+j)
 					}
-					a.Screen.SetContent(screenX, screenY, '█', nil, tcell.StyleDefault.Foreground(palette[idx]))
-				} else {
-					a.Screen.SetContent(screenX, screenY, ' ', nil, tcell.StyleDefault)
 				}
-				a.LastGrid[x][y] = newVal
+				cell = a.Mode.Compose(pixels)
+			}
+			if showBrush && a.onBrushEdge(cx, cy) {
+				cell.Style = cell.Style.Background(tcell.ColorDarkSlateGray)
+			}
+			if preview && a.brushMask[idx] {
+				cell.Style = cell.Style.Background(tcell.ColorDarkSlateGray)
+				a.brushMask[idx] = false
+			}
+
+			if cell != a.LastCells[idx] {
+				a.Screen.SetContent(cx+simulation.SidebarWidth, cy, cell.Rune, nil, cell.Style)
+				a.LastCells[idx] = cell
 			}
 		}
 	}
 
-	a.LastCursorX = int(a.CursorX)
-	a.LastCursorY = int(a.CursorY)
-
 	a.DrawMenu()
 	a.Screen.Show()
 	a.LastRenderTime = time.Since(renderStart)
+	if a.stats != nil {
+		a.stats.addRender(a.LastRenderTime)
+	}
+}
+
+func (a *App) pixelColor(x, y int) tcell.Color {
+	if x >= a.SimW || y >= a.SimH {
+		return render.Empty
+	}
+	idx := x + y*a.SimW
+	if cover := a.CurrentCover[idx]; cover == coverWall {
+		return a.wallColor(idx)
+	} else if cover > 0 {
+		return a.bodyColor(cover - 1)
+	}
+	val := a.CurrentGrid[idx]
+	if val > 0 && render.ColorModes[a.ColorModeIdx].Value != nil {
+		// the weighted mean of the splatted values decides the color
+		palette := a.colorModePalette()
+		return palette[render.PaletteIndex(a.FieldSum[idx]/float64(val), a.RangeMin, a.RangeMax, len(palette))]
+	}
+	if val > 0 {
+		palette := a.speciesPalette(a.CurrentSpecies[idx])
+		idx := val / 2
+		if idx >= len(palette) {
+			idx = len(palette) - 1
+		}
+		return palette[idx]
+	}
+	return render.Empty
+}
+
+func (a *App) cursorCell() render.Cell {
+	cursorChar := '▼'
+	color := tcell.ColorRed
+	if a.MouseMode == ModeWall {
+		cursorChar = '■'
+		color = tcell.ColorGray
+		if a.WallMaterialIdx != int(simulation.WallDefault) {
+			color = wallMaterialColors[a.WallMaterialIdx]
+		}
+	} else if a.MouseMode == ModeErase {
+		cursorChar = 'X'
+		color = tcell.ColorRed
+	} else if a.MouseMode == ModeEmitter {
+		return sourceMarker(render.Source{Angle: a.EmitAngle})
+	} else if a.MouseMode == ModeDrain {
+		return sourceMarker(render.Source{Drain: true})
+	} else if a.MouseMode == ModeAttract {
+		cursorChar = '+'
+		color = tcell.ColorYellow
+	} else if a.MouseMode == ModeRepel {
+		cursorChar = '-'
+		color = tcell.ColorYellow
+	} else if a.MouseMode == ModeDrag {
+		cursorChar = '@'
+		color = tcell.ColorYellow
+	} else if a.MouseMode == ModeHeat {
+		cursorChar = '■'
+		color = tcell.ColorRed
+	} else if a.MouseMode == ModeCool {
+		cursorChar = '■'
+		color = tcell.ColorDarkCyan
+	} else if a.MouseMode == ModeBody {
+		cursorChar = bodyGlyphs[a.BodyShapeIdx]
+		color = tcell.ColorSandyBrown
+	}
+	return render.Cell{Rune: cursorChar, Style: tcell.StyleDefault.Foreground(color)}
 }
 
 func (a *App) DrawMenu() {
@@ -116,8 +230,24 @@ func (a *App) DrawMenu() {
 	ui.DrawText(a.Screen, 2, 1, a.StyleMenuBg, "FLUID SIMULATION")
 	ui.DrawText(a.Screen, 2, 2, a.StyleMenuBg, "----------------")
 
+	// scroll the menu so the selected item stays visible on short terminals, the footer
+	// below it keeps its lines
+	a.layoutFooter()
+	visible := max(h-4-len(a.footer), 5)
+	if a.SelectedItem < a.MenuScroll {
+		a.MenuScroll = a.SelectedItem
+	} else if a.SelectedItem >= a.MenuScroll+visible {
+		a.MenuScroll = a.SelectedItem - visible + 1
+	}
+	a.MenuScroll = max(min(a.MenuScroll, len(a.MenuItems)-visible), 0)
+	last := min(a.MenuScroll+visible, len(a.MenuItems))
+
 	yPos := 4
-	for i, item := range a.MenuItems {
+	if a.MenuScroll > 0 {
+		ui.DrawText(a.Screen, simulation.SidebarWidth-3, yPos, a.StyleMenuBg, "↑")
+	}
+	for i := a.MenuScroll; i < last; i++ {
+		item := a.MenuItems[i]
 		style := a.StyleMenuBg
 		prefix := " "
 		if i == a.SelectedItem {
@@ -125,76 +255,425 @@ func (a *App) DrawMenu() {
 			prefix = ">"
 		}
 
-		valStr := ""
+		text := append(a.menuText[:0], prefix...)
+		text = append(text, ' ')
+		text = append(text, item.Name...)
+		for n := utf8.RuneCountInString(item.Name); n < 9; n++ {
+			text = append(text, ' ')
+		}
+		text = append(text, ' ')
 		switch item.Type {
 		case "preset_enum":
-			valStr = a.ActivePresetName
-		case "float":
-			valStr = fmt.Sprintf(item.Fmt, *item.Val.(*float64))
+			text = append(text, a.ActivePresetName...)
+		case "float", "tool":
+			text = appendFloat(text, item.Fmt, *item.Val.(*float64))
 		case "int":
-			valStr = fmt.Sprintf(item.Fmt, *item.Val.(*int))
+			text = appendInt(text, item.Fmt, *item.Val.(*int))
 		case "enum":
 			idx := *item.Val.(*int)
-			valStr = fmt.Sprintf(item.Fmt, a.Palettes[idx].Name)
+			text = appendString(text, item.Fmt, a.Palettes[idx].Name)
+		case "species_enum":
+			idx := *item.Val.(*int)
+			text = appendString(text, item.Fmt, a.Species[idx].Name)
+		case "wall_enum":
+			idx := *item.Val.(*int)
+			text = appendString(text, item.Fmt, simulation.WallMaterialNames[idx])
+		case "walltool_enum":
+			idx := *item.Val.(*int)
+			text = appendString(text, item.Fmt, wallToolNames[idx])
+		case "body_enum":
+			idx := *item.Val.(*int)
+			text = appendString(text, item.Fmt, bodyShapes[idx].Name)
+		case "render_enum":
+			idx := *item.Val.(*int)
+			text = appendString(text, item.Fmt, render.Modes[idx].Name)
+		case "color_enum":
+			idx := *item.Val.(*int)
+			text = appendString(text, item.Fmt, render.ColorModes[idx].Name)
+		case "toggle":
+			if *item.Val.(*bool) {
+				text = append(text, "On"...)
+			} else {
+				text = append(text, "Off"...)
+			}
 		case "action":
-			valStr = item.Fmt
+			text = append(text, item.Fmt...)
 		}
+		a.menuText = text
+		ui.DrawBytes(a.Screen, 2, yPos, style, text)
+		yPos++
+	}
+	if last < len(a.MenuItems) {
+		ui.DrawText(a.Screen, simulation.SidebarWidth-3, yPos-1, a.StyleMenuBg, "↓")
+	}
 
-		line := fmt.Sprintf("%s %-9s %s", prefix, item.Name, valStr)
-		ui.DrawText(a.Screen, 2, yPos, style, line)
+	for _, line := range a.footer {
+		if line.legend {
+			a.drawLegend(2, yPos)
+		} else {
+			ui.DrawBytes(a.Screen, 2, yPos, line.style, a.footerText[line.start:line.end])
+		}
 		yPos++
 	}
 
-	yPos += 2
-	ui.DrawText(a.Screen, 2, yPos, a.StyleMenuBg, "CONTROLS:")
-	yPos++
-	ui.DrawText(a.Screen, 2, yPos, a.StyleMenuBg, " [Tab] Cycle Mode")
-	yPos++
-	ui.DrawText(a.Screen, 2, yPos, a.StyleMenuBg, " [Mouse LB] Spawn/Draw/Erase")
-	yPos++
-	ui.DrawText(a.Screen, 2, yPos, a.StyleMenuBg, " [ C ] Clear Walls")
-	yPos++
-	ui.DrawText(a.Screen, 2, yPos, a.StyleMenuBg, " [Space] Spawn Fluid")
-	yPos++
-	ui.DrawText(a.Screen, 2, yPos, a.StyleMenuBg, " [ P ] Pause")
-	yPos++
-	ui.DrawText(a.Screen, 2, yPos, a.StyleMenuBg, " [ R ] Clear Fluid")
-
-	yPos += 2
-	status := "RUNNING"
+	if a.InputMode {
+		title := "Save Preset As:"
+		switch a.InputAction {
+		case InputSaveScene:
+			title = "Save Scene To:"
+		case InputLoadScene:
+			title = "Load Scene From:"
+		}
+		ui.DrawInputOverlay(a.Screen, title, a.InputText)
+	}
+}
+
+// layoutFooter lists the lines below the menu in a.footer: the controls, the run status
+// and the timings, each block after an empty line. The text is appended to a reused
+// buffer, so a frame formats it without allocating.
+func (a *App) layoutFooter() {
+	a.footer = a.footer[:0]
+	a.footerText = a.footerText[:0]
+
+	a.endFooterLine(a.StyleMenuBg)
+	a.footerText = append(a.footerText, "CONTROLS:"...)
+	a.endFooterLine(a.StyleMenuBg)
+	for _, control := range menuControls {
+		a.footerText = append(a.footerText, control...)
+		a.endFooterLine(a.StyleMenuBg)
+	}
+
+	a.endFooterLine(a.StyleMenuBg)
+	a.footerText = append(a.footerText, "Status: "...)
 	if a.UIConfig.IsPaused {
-		status = "PAUSED"
+		a.footerText = append(a.footerText, "PAUSED"...)
+	} else {
+		a.footerText = append(a.footerText, "RUNNING"...)
+	}
+	if a.Replaying {
+		a.footerText = append(a.footerText, " REPLAY"...)
+	} else if a.Recording {
+		a.footerText = append(a.footerText, " REC"...)
+	}
+	a.endFooterLine(a.StyleMenuBg)
+	a.footerText = append(a.footerText, "Frame: "...)
+	a.footerText = strconv.AppendUint(a.footerText, a.Simulated, 10)
+	if a.SubStep > 0 {
+		a.footerText = append(a.footerText, "  Substep "...)
+		a.footerText = strconv.AppendInt(a.footerText, int64(a.SubStep), 10)
+		a.footerText = append(a.footerText, '/')
+		a.footerText = strconv.AppendInt(a.footerText, int64(a.SubSteps), 10)
+	}
+	a.endFooterLine(a.StyleMenuBg)
+	if a.Rewind > 0 {
+		a.footerText = append(a.footerText, "Rewind: -"...)
+		a.footerText = strconv.AppendInt(a.footerText, int64(a.Rewind), 10)
+		a.footerText = append(a.footerText, " of "...)
+		a.footerText = strconv.AppendInt(a.footerText, int64(a.RewindLen-1), 10)
+		a.footerText = append(a.footerText, " frames"...)
+		a.endFooterLine(a.StyleMenuBg)
+	}
+	if a.StatusMsg != "" {
+		a.footerText = append(a.footerText, truncate(a.StatusMsg, simulation.SidebarWidth-4)...)
+		a.endFooterLine(a.StyleMenuBg)
 	}
-	ui.DrawText(a.Screen, 2, yPos, a.StyleMenuBg, fmt.Sprintf("Status: %s", status))
 
-	yPos++
 	modeStr := "SPAWN"
 	if a.MouseMode == ModeWall {
 		modeStr = "WALLS"
 	} else if a.MouseMode == ModeErase {
 		modeStr = "ERASE"
+	} else if a.MouseMode == ModeEmitter {
+		modeStr = "EMITTER"
+	} else if a.MouseMode == ModeDrain {
+		modeStr = "DRAIN"
+	} else if a.MouseMode == ModeAttract {
+		modeStr = "ATTRACT"
+	} else if a.MouseMode == ModeRepel {
+		modeStr = "REPEL"
+	} else if a.MouseMode == ModeDrag {
+		modeStr = "DRAG"
+	} else if a.MouseMode == ModeHeat {
+		modeStr = "HEAT"
+	} else if a.MouseMode == ModeCool {
+		modeStr = "COOL"
+	} else if a.MouseMode == ModeBody {
+		modeStr = "BODY"
+	}
+	a.footerText = append(a.footerText, "MODE:   "...)
+	a.footerText = append(a.footerText, modeStr...)
+	if a.IsWallMode() && a.WallToolIdx != ToolFree {
+		a.footerText = append(a.footerText, ' ')
+		a.footerText = append(a.footerText, wallToolNames[a.WallToolIdx]...)
+	}
+	a.endFooterLine(tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorYellow))
+	a.footerText = append(a.footerText, "Gravity: "...)
+	a.footerText = a.appendGravity(a.footerText)
+	a.endFooterLine(a.StyleMenuBg)
+	if render.ColorModes[a.ColorModeIdx].Value != nil {
+		a.endFooterLine(a.StyleMenuBg)
+		a.footer[len(a.footer)-1].legend = true
 	}
 
-	ui.DrawText(a.Screen, 2, yPos, tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorYellow), fmt.Sprintf("MODE:   %s", modeStr))
-	yPos += 2
-	ui.DrawText(a.Screen, 2, yPos, a.StyleMenuBg, fmt.Sprintf("Particles: %d", len(a.CurrentParticles)))
-	yPos++
-	ui.DrawText(a.Screen, 2, yPos, a.StyleMenuBg, fmt.Sprintf("FPS: %d", a.Fps))
-	yPos++
-	ui.DrawText(a.Screen, 2, yPos, a.StyleMenuBg, fmt.Sprintf("Physics: %v", a.LastPhysTime.Round(time.Microsecond)))
-	yPos++
-	ui.DrawText(a.Screen, 2, yPos, a.StyleMenuBg, fmt.Sprintf("Render: %v", a.LastRenderTime.Round(time.Microsecond)))
+	a.endFooterLine(a.StyleMenuBg)
+	a.footerText = append(a.footerText, "Particles: "...)
+	a.footerText = strconv.AppendInt(a.footerText, int64(len(a.CurrentParticles)), 10)
+	if a.MaxParticles > 0 {
+		a.footerText = append(a.footerText, " / "...)
+		a.footerText = strconv.AppendInt(a.footerText, int64(a.MaxParticles), 10)
+	}
+	a.endFooterLine(a.StyleMenuBg)
+	a.footerText = append(a.footerText, "FPS: "...)
+	a.footerText = strconv.AppendInt(a.footerText, int64(a.Fps), 10)
+	a.endFooterLine(a.StyleMenuBg)
+	a.footerText = append(a.footerText, "Physics: "...)
+	a.footerText = appendDuration(a.footerText, a.LastPhysTime)
+	a.endFooterLine(a.StyleMenuBg)
+	a.footerText = append(a.footerText, "Render: "...)
+	a.footerText = appendDuration(a.footerText, a.LastRenderTime)
+	a.endFooterLine(a.StyleMenuBg)
+	a.footerText = append(a.footerText, "Speed: "...)
+	a.footerText = strconv.AppendFloat(a.footerText, a.TimeScale, 'g', -1, 64)
+	a.footerText = append(a.footerText, "x  Substeps: "...)
+	a.footerText = strconv.AppendInt(a.footerText, int64(a.SubSteps), 10)
+	a.endFooterLine(a.StyleMenuBg)
+	// the steps of one tick have to finish before the next one
+	if a.AvgPhysTime > a.StepInterval && a.StepInterval > 0 && len(a.CurrentParticles) > 0 {
+		// the solver time grows about linearly with the particle count
+		fit := int64(len(a.CurrentParticles)) * int64(a.StepInterval) / int64(a.AvgPhysTime)
+		warn := tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorRed)
+		a.footerText = append(a.footerText, "Over "...)
+		a.footerText = appendDuration(a.footerText, a.StepInterval)
+		a.footerText = append(a.footerText, " step budget"...)
+		a.endFooterLine(warn)
+		a.footerText = append(a.footerText, '~')
+		a.footerText = strconv.AppendInt(a.footerText, fit, 10)
+		a.footerText = append(a.footerText, " particles fit"...)
+		a.endFooterLine(warn)
+	}
+}
 
-	if a.InputMode {
-		ui.DrawInputOverlay(a.Screen, "Save Preset As:", a.InputText)
+// endFooterLine ends a footer line with the text appended since the previous one
+func (a *App) endFooterLine(style tcell.Style) {
+	start := 0
+	if n := len(a.footer); n > 0 {
+		start = a.footer[n-1].end
 	}
+	a.footer = append(a.footer, footerLine{start: start, end: len(a.footerText), style: style})
 }
 
-func (a *App) ForceRedraw() {
-	a.Screen.Clear()
-	for i := range a.LastGrid {
-		for j := range a.LastGrid[i] {
-			a.LastGrid[i][j] = -1
+// drawLegend shows the palette with the values at both ends of the color range
+func (a *App) drawLegend(x, y int) {
+	palette := a.colorModePalette()
+	text := strconv.AppendFloat(a.menuText[:0], a.RangeMin, 'f', 2, 64)
+	text = append(text, ' ')
+	ui.DrawBytes(a.Screen, x, y, a.StyleMenuBg, text)
+	x += len(text)
+	for _, c := range palette {
+		a.Screen.SetContent(x, y, '█', nil, tcell.StyleDefault.Foreground(c).Background(tcell.ColorBlack))
+		x++
+	}
+	text = append(text[:0], ' ')
+	text = strconv.AppendFloat(text, a.RangeMax, 'f', 2, 64)
+	ui.DrawBytes(a.Screen, x, y, a.StyleMenuBg, text)
+	a.menuText = text
+}
+
+// colorModePalette is the palette value color modes map onto
+func (a *App) colorModePalette() []tcell.Color {
+	if colors := render.ColorModes[a.ColorModeIdx].Colors; colors != nil {
+		return colors
+	}
+	return a.Palettes[a.UIConfig.PaletteIdx].Colors
+}
+
+// wallColor tints hot and cold walls, idx is the simulation cell
+func (a *App) wallColor(idx int) tcell.Color {
+	walls := a.walls()
+	if walls == nil {
+		return tcell.ColorWhite
+	}
+	switch walls.Heat[idx] {
+	case simulation.HeatHot:
+		return tcell.ColorRed
+	case simulation.HeatCold:
+		return tcell.ColorDarkCyan
+	}
+	return wallMaterialColors[walls.Materials[idx]]
+}
+
+// walls returns the walls of the last frame, nil when they do not match the grids
+func (a *App) walls() *render.Walls {
+	if w := a.CurrentWalls; w != nil && w.Width == a.SimW && w.Height == a.SimH {
+		return w
+	}
+	return nil
+}
+
+// wallMaterialColors are the colors of the wall materials
+var wallMaterialColors = []tcell.Color{
+	simulation.WallDefault:  tcell.ColorWhite,
+	simulation.WallSlippery: tcell.ColorLightSkyBlue,
+	simulation.WallSticky:   tcell.ColorOliveDrab,
+	simulation.WallBouncy:   tcell.ColorHotPink,
+}
+
+// bodyGlyphs are the cursors of the body shapes
+var bodyGlyphs = []rune{'▬', '●', '▲', '◡'}
+
+// markBody fills the simulation cells whose centers lie inside the body outline
+func (a *App) markBody(i int) {
+	b := &a.CurrentBodies[i]
+	if len(b.Outline) == 0 {
+		return
+	}
+	minX, minY := b.Outline[0].X, b.Outline[0].Y
+	maxX, maxY := minX, minY
+	for _, v := range b.Outline {
+		minX, maxX = math.Min(minX, v.X), math.Max(maxX, v.X)
+		minY, maxY = math.Min(minY, v.Y), math.Max(maxY, v.Y)
+	}
+
+	for y := max(int(minY), 0); y <= min(int(maxY), a.SimH-1); y++ {
+		for x := max(int(minX), 0); x <= min(int(maxX), a.SimW-1); x++ {
+			idx := x + y*a.SimW
+			if a.CurrentCover[idx] != coverWall && b.Contains(float64(x)+0.5, float64(y)+0.5) {
+				a.CurrentCover[idx] = i + 1
+			}
 		}
 	}
 }
+
+// bodyColor tells floating wood from sinking stone, the dragged body is highlighted
+func (a *App) bodyColor(i int) tcell.Color {
+	b := &a.CurrentBodies[i]
+	switch {
+	case b.Held:
+		return tcell.ColorYellow
+	case b.Density <= 0:
+		return tcell.ColorSilver
+	case b.Density < 1:
+		return tcell.ColorSienna
+	default:
+		return tcell.ColorDimGray
+	}
+}
+
+func (a *App) splat(idx, species int, value float64) {
+	if a.CurrentGrid[idx] == 0 {
+		a.CurrentSpecies[idx] = species
+	}
+	a.CurrentGrid[idx] += 1
+	a.FieldSum[idx] += value
+}
+
+func (a *App) speciesPalette(species int) []tcell.Color {
+	if species < len(a.SpeciesPalettes) && a.SpeciesPalettes[species] >= 0 {
+		return a.Palettes[a.SpeciesPalettes[species]].Colors
+	}
+	return a.Palettes[a.UIConfig.PaletteIdx].Colors
+}
+
+// onBrushEdge reports whether the terminal cell lies on the outline of the force brush
+func (a *App) onBrushEdge(cx, cy int) bool {
+	sx, sy := float64(a.Mode.ScaleX), float64(a.Mode.ScaleY)
+	dx := (float64(cx)+0.5)*sx - (a.CursorX + 0.5)
+	dy := (float64(cy)+0.5)*sy - (a.CursorY + 0.5)
+	return math.Abs(math.Hypot(dx, dy)-a.ForceRadius) < math.Max(sx, sy)*0.5
+}
+
+// gravityIndicator shows the direction gravity pulls in
+func (a *App) appendGravity(b []byte) []byte {
+	cfg := a.UIConfig
+	if cfg.ZeroGravity || cfg.Gravity == 0 {
+		return append(b, "off"...)
+	}
+	// screen angle, 0 points right and 90 down like emitters
+	angle := math.Atan2(cfg.GravityY, cfg.GravityX) * 180 / math.Pi
+	b = utf8.AppendRune(b, emitterGlyphs[directionIndex(angle)])
+	b = append(b, ' ')
+	if !math.Signbit(cfg.GravityAngle) {
+		b = append(b, '+')
+	}
+	b = strconv.AppendFloat(b, cfg.GravityAngle, 'f', 0, 64)
+	return append(b, "°"...)
+}
+
+// emitters point in their direction, drains are rings
+var emitterGlyphs = []rune{'→', '↘', '↓', '↙', '←', '↖', '↑', '↗'}
+
+func sourceMarker(src render.Source) render.Cell {
+	style := tcell.StyleDefault.Background(tcell.ColorBlack)
+	if src.Drain {
+		return render.Cell{Rune: '◎', Style: style.Foreground(tcell.ColorFuchsia)}
+	}
+	return render.Cell{Rune: emitterGlyphs[directionIndex(src.Angle)], Style: style.Foreground(tcell.ColorLime)}
+}
+
+// directionIndex picks the arrow closest to angle in degrees
+func directionIndex(angle float64) int {
+	idx := int(math.Round(angle/45)) % len(emitterGlyphs)
+	if idx < 0 {
+		idx += len(emitterGlyphs)
+	}
+	return idx
+}
+
+// appendFloat appends v formatted with a "%.Nf" format without going through fmt, which
+// allocates for its arguments. Other formats still use fmt.
+func appendFloat(b []byte, format string, v float64) []byte {
+	if len(format) == 4 && format[:2] == "%." && format[2] >= '0' && format[2] <= '9' && format[3] == 'f' {
+		return strconv.AppendFloat(b, v, 'f', int(format[2]-'0'), 64)
+	}
+	return fmt.Appendf(b, format, v)
+}
+
+// appendInt is appendFloat for "%d"
+func appendInt(b []byte, format string, v int) []byte {
+	if format == "%d" {
+		return strconv.AppendInt(b, int64(v), 10)
+	}
+	return fmt.Appendf(b, format, v)
+}
+
+// appendString is appendFloat for "%s"
+func appendString(b []byte, format, v string) []byte {
+	if format == "%s" {
+		return append(b, v...)
+	}
+	return fmt.Appendf(b, format, v)
+}
+
+// appendDuration appends d rounded to microseconds, the way time.Duration prints it
+// below a minute
+func appendDuration(b []byte, d time.Duration) []byte {
+	d = d.Round(time.Microsecond)
+	switch {
+	case d == 0:
+		return append(b, "0s"...)
+	case d < time.Millisecond && d > -time.Millisecond:
+		b = strconv.AppendInt(b, int64(d/time.Microsecond), 10)
+		return append(b, "µs"...)
+	case d < time.Second && d > -time.Second:
+		b = strconv.AppendFloat(b, float64(d)/float64(time.Millisecond), 'f', -1, 64)
+		return append(b, "ms"...)
+	}
+	b = strconv.AppendFloat(b, d.Seconds(), 'f', -1, 64)
+	return append(b, 's')
+}
+
+// truncate cuts s to at most n runes, status messages hold file names that may not be ASCII
+func truncate(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+func (a *App) ForceRedraw() {
+	a.Screen.Clear()
+	clear(a.LastCells)
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/null-enjoyer/terminal-fluid-simulation/render"
+)
+
+// BenchmarkFramePipeline times a frame from the simulation to the drawn screen: the
+// simulation fills and publishes a frame, the app takes it over and renders it
+func BenchmarkFramePipeline(b *testing.B) {
+	screen := tcell.NewSimulationScreen("")
+	a := New(Options{Screen: benchScreen{screen}, PhysicsRate: 1000})
+	defer a.Screen.Fini()
+	screen.SetSize(120, 40)
+	a.Resize()
+	a.Sim.ProcessCommands()
+	for x := 10; x < a.SimW-10; x += 8 {
+		a.Sim.Spawn(float64(x), float64(a.SimH/3), 8, 8, 0)
+	}
+
+	go a.Sim.Run()
+	defer a.Sim.Close()
+	frame := func() {
+		a.applyFrame(<-a.Sim.RenderChan)
+		a.Render()
+	}
+	// the first frames grow the frame and render buffers
+	for range 30 {
+		frame()
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		frame()
+	}
+}
+
+// TestCrowdedCell renders a cell packed with more particles than any marker value, it
+// must draw as fluid rather than be taken for a wall or a body
+func TestCrowdedCell(t *testing.T) {
+	screen := tcell.NewSimulationScreen("")
+	a := New(Options{Screen: benchScreen{screen}})
+	defer a.Screen.Fini()
+	screen.SetSize(120, 40)
+	a.Resize()
+
+	a.CurrentParticles = make([]render.Point, 400)
+	for i := range a.CurrentParticles {
+		a.CurrentParticles[i] = render.Point{X: 10, Y: 10}
+	}
+	a.Render()
+	if a.CurrentCover[10+10*a.SimW] != 0 {
+		t.Errorf("crowded cell marked as cover %d", a.CurrentCover[10+10*a.SimW])
+	}
+	if a.pixelColor(10, 10) == render.Empty {
+		t.Error("crowded cell drawn empty")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"scene.json", 20, "scene.json"},
+		{"scene.json", 5, "scene"},
+		{"szenen/größe.json", 11, "szenen/größ"},
+		{"水槽.json", 2, "水槽"},
+		{"水槽.json", 0, ""},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
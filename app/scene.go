@@ -0,0 +1,92 @@
+package app
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/scene"
+	"github.com/null-enjoyer/terminal-fluid-simulation/simulation"
+)
+
+const DefaultScenePath = "scene.json"
+
+func (a *App) SaveScene(path string) error {
+	done := make(chan *scene.Scene, 1)
+	a.Sim.ControlChan <- func(s *simulation.Simulation) { done <- scene.Capture(s) }
+	sc := <-done
+
+	sc.Preset = a.ActivePresetName
+	sc.Palette = a.Palettes[a.UIConfig.PaletteIdx].Name
+	sc.Config.PaletteName = sc.Palette
+	sc.Config.RenderMode = a.Mode.Name
+
+	if err := scene.Save(path, sc); err != nil {
+		return err
+	}
+	a.ScenePath = path
+	a.StatusMsg = fmt.Sprintf("Saved %s", path)
+	return nil
+}
+
+func (a *App) LoadScene(path string) error {
+	sc, err := scene.Load(path)
+	if err != nil {
+		return err
+	}
+	if err := sc.CheckSpecies(a.Species); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	// settings first, a render mode change has to resize the domain before the scene is applied
+	a.applySceneSettings(sc)
+	cfg := sc.Config
+	a.Sim.CmdChan <- simulation.Command{Kind: simulation.CmdLoadState, Config: &cfg, State: &sc.State}
+	a.ScenePath = path
+	a.StatusMsg = fmt.Sprintf("Loaded %s", path)
+	a.ForceRedraw()
+	return nil
+}
+
+// loadStartupScene applies the scene directly, the simulation goroutine is not running yet.
+// A missing file is not an error, the path is kept as the default save location.
+func (a *App) loadStartupScene(path string) error {
+	a.ScenePath = path
+
+	sc, err := scene.Load(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := sc.CheckSpecies(a.Species); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	a.applySceneSettings(sc)
+	a.Sim.ProcessCommands()
+	sc.Apply(a.Sim)
+	return nil
+}
+
+func (a *App) applySceneSettings(sc *scene.Scene) {
+	a.UIConfig = sc.Config
+	a.UIConfig.PaletteName = sc.Palette
+	a.SyncSeed()
+	sc.Config.Seed = a.UIConfig.Seed
+	a.SyncPalette()
+	a.SyncRenderMode()
+	a.ApplyRenderMode()
+
+	a.ActivePresetName = sc.Preset
+	if a.ActivePresetName == "" {
+		a.ActivePresetName = "Custom"
+	}
+	for i, n := range a.PresetNames {
+		if n == a.ActivePresetName {
+			a.ActivePresetIdx = i
+			break
+		}
+	}
+
+	a.InitMenu()
+}
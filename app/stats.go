@@ -0,0 +1,29 @@
+package app
+
+import (
+	"runtime"
+	"time"
+)
+
+// frameStats records the frame times in the app goroutine for the UI harness in the
+// tests. The memory statistics are read when the measurement starts, after warmup
+// rendered frames.
+type frameStats struct {
+	warmup  int
+	renders []time.Duration
+	phys    []time.Duration
+	before  runtime.MemStats
+}
+
+func (st *frameStats) addRender(d time.Duration) {
+	st.renders = append(st.renders, d)
+	if len(st.renders) == st.warmup {
+		runtime.ReadMemStats(&st.before)
+	}
+}
+
+func (st *frameStats) addPhys(d time.Duration) {
+	if len(st.renders) >= st.warmup {
+		st.phys = append(st.phys, d)
+	}
+}
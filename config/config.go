@@ -3,27 +3,93 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"sort"
+	"strconv"
+	"strings"
 )
 
+// Screen edge modes. Particles bounce off solid edges and are deleted past open ones.
+// Periodic edges wrap around to the opposite edge, which is then periodic as well.
+const (
+	EdgeSolid    = "solid"
+	EdgePeriodic = "periodic"
+	EdgeOpen     = "open"
+)
+
+// UnlimitedParticles as max_particles lets the particle count grow without a cap
+const UnlimitedParticles = -1
+
+// ParseMaxParticles reads a particle cap given as a count or as "unlimited"
+func ParseMaxParticles(value string) (int, error) {
+	if strings.EqualFold(value, "unlimited") {
+		return UnlimitedParticles, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid particle cap %q, expected a positive count or \"unlimited\"", value)
+	}
+	return n, nil
+}
+
 type PhysicsConfig struct {
 	Gravity           float64 `json:"gravity"`
+	GravityAngle      float64 `json:"gravity_angle,omitempty"` // degrees from straight down, positive pulls right
+	ZeroGravity       bool    `json:"zero_gravity,omitempty"`
+	GravityX          float64 `json:"-"`
+	GravityY          float64 `json:"-"`
 	Stiffness         float64 `json:"stiffness"`
 	StiffnessNear     float64 `json:"stiffness_near"`
 	RestDensity       float64 `json:"rest_density"`
 	Viscosity         float64 `json:"viscosity"`
 	Damping           float64 `json:"damping"`
 	InteractionRad    float64 `json:"interaction_rad"`
+	SurfaceTension    float64 `json:"surface_tension,omitempty"`    // cohesion between neighbors
+	Adhesion          float64 `json:"adhesion,omitempty"`           // pull of fluid towards walls
+	WallFriction      float64 `json:"wall_friction,omitempty"`      // part of the velocity along a wall lost on contact
+	WallRestitution   float64 `json:"wall_restitution,omitempty"`   // part of the velocity into a wall bounced back
+	BorderFriction    float64 `json:"border_friction,omitempty"`    // wall_friction of the screen borders
+	BorderRestitution float64 `json:"border_restitution,omitempty"` // wall_restitution of the screen borders
+	EdgeLeft          string  `json:"edge_left,omitempty"`          // EdgeSolid, EdgePeriodic or EdgeOpen, empty is solid
+	EdgeRight         string  `json:"edge_right,omitempty"`
+	EdgeTop           string  `json:"edge_top,omitempty"`
+	EdgeBottom        string  `json:"edge_bottom,omitempty"`
+	WrapX             bool    `json:"-"`                          // left and right edges are periodic
+	WrapY             bool    `json:"-"`                          // top and bottom edges are periodic
+	SpringStiffness   float64 `json:"spring_stiffness,omitempty"` // 0 disables viscoelastic springs
+	YieldRatio        float64 `json:"yield_ratio,omitempty"`      // deformation springs tolerate elastically
+	Plasticity        float64 `json:"plasticity,omitempty"`       // how fast rest lengths follow beyond the yield
+	AmbientTemp       float64 `json:"ambient_temp,omitempty"`
+	Conductivity      float64 `json:"conductivity,omitempty"`      // heat exchange between neighbors and with hot/cold cells
+	CoolingRate       float64 `json:"cooling_rate,omitempty"`      // fraction of the difference to ambient lost per frame
+	Buoyancy          float64 `json:"buoyancy,omitempty"`          // lift per degree above ambient
+	ThermalViscosity  float64 `json:"thermal_viscosity,omitempty"` // viscosity growth per 100 degrees below ambient
 	InteractionRadSq  float64 `json:"-"`
 	InvInteractionRad float64 `json:"-"`
 	SpawnCount        int     `json:"spawn_count"`
 	PaletteName       string  `json:"palette"`
 	PaletteIdx        int     `json:"-"` // Runtime only
+	RenderMode        string  `json:"render_mode,omitempty"`
+	RenderModeIdx     int     `json:"-"`                       // Runtime only
+	SpawnSpecies      string  `json:"spawn_species,omitempty"` // species selected with the preset
 	IsPaused          bool    `json:"is_paused"`
+	Seed              int64   `json:"seed,omitempty"` // non-zero makes runs repeatable
 }
 
 func (c *PhysicsConfig) UpdateDerived() {
+	c.GravityAngle = math.Remainder(c.GravityAngle, 360)
+	if c.ZeroGravity {
+		c.GravityX, c.GravityY = 0, 0
+	} else {
+		angle := c.GravityAngle * math.Pi / 180
+		c.GravityX = c.Gravity * math.Sin(angle)
+		c.GravityY = c.Gravity * math.Cos(angle)
+	}
+
+	c.WrapX = c.EdgeLeft == EdgePeriodic || c.EdgeRight == EdgePeriodic
+	c.WrapY = c.EdgeTop == EdgePeriodic || c.EdgeBottom == EdgePeriodic
+
 	c.InteractionRadSq = c.InteractionRad * c.InteractionRad
 	if c.InteractionRad != 0 {
 		c.InvInteractionRad = 1.0 / c.InteractionRad
@@ -32,6 +98,28 @@ func (c *PhysicsConfig) UpdateDerived() {
 	}
 }
 
+// Species describes one fluid material. Zero RestDensity or Viscosity
+// inherits the value from the active preset, an empty palette uses the
+// preset palette and a zero Temperature spawns at the ambient temperature.
+//
+// Particles turn into the CoolInto species below CoolBelow and into the
+// HeatInto species above HeatAbove. Static species do not move, Lift
+// reverses that fraction of gravity.
+type Species struct {
+	Name        string  `json:"name"`
+	Mass        float64 `json:"mass"`
+	RestDensity float64 `json:"rest_density,omitempty"`
+	Viscosity   float64 `json:"viscosity,omitempty"`
+	PaletteName string  `json:"palette,omitempty"`
+	Temperature float64 `json:"temperature,omitempty"`
+	CoolBelow   float64 `json:"cool_below,omitempty"`
+	CoolInto    string  `json:"cool_into,omitempty"`
+	HeatAbove   float64 `json:"heat_above,omitempty"`
+	HeatInto    string  `json:"heat_into,omitempty"`
+	Static      bool    `json:"static,omitempty"`
+	Lift        float64 `json:"lift,omitempty"`
+}
+
 type HexPalette struct {
 	Name   string   `json:"name"`
 	Colors []string `json:"colors"`
@@ -40,6 +128,19 @@ type HexPalette struct {
 type AppConfig struct {
 	Presets  map[string]PhysicsConfig `json:"presets"`
 	Palettes []HexPalette             `json:"color_palettes"`
+	Species  []Species                `json:"species"`
+	Workers  int                      `json:"workers,omitempty"` // 0 uses one worker per CPU
+	// MaxParticles caps the particle count, 0 uses the built-in cap and UnlimitedParticles removes it
+	MaxParticles int `json:"max_particles,omitempty"`
+	// PhysicsRate is the number of simulation steps per second and RenderFPS the number
+	// of frames drawn per second, 0 uses 60 for both
+	PhysicsRate float64 `json:"physics_rate,omitempty"`
+	RenderFPS   int     `json:"render_fps,omitempty"`
+	// RewindFrames is the depth of the rewind buffer, 0 turns it off
+	RewindFrames int `json:"rewind_frames,omitempty"`
+
+	// fixed min/max per color mode name, overriding the built-in ranges
+	ColorRanges map[string][2]float64 `json:"color_ranges,omitempty"`
 }
 
 func LoadSettings(path string) (*AppConfig, error) {
@@ -66,6 +167,10 @@ func LoadSettings(path string) (*AppConfig, error) {
 		appConfig.Presets[k] = v
 	}
 
+	if len(appConfig.Species) == 0 {
+		appConfig.Species = DefaultSpecies()
+	}
+
 	return &appConfig, nil
 }
 
@@ -97,20 +202,106 @@ func NewDefaultConfig() *AppConfig {
 				Viscosity:      0.02,
 				Damping:        0.91,
 				InteractionRad: 3,
+				AmbientTemp:    20,
+				Conductivity:   0.1,
+				CoolingRate:    0.002,
+				Buoyancy:       0.0002,
 				SpawnCount:     20,
 				PaletteName:    "Water",
 				IsPaused:       false,
 			},
 			"Magma": {
-				Gravity:        0.03,
+				Gravity:          0.03,
+				Stiffness:        0.06,
+				StiffnessNear:    0.08,
+				RestDensity:      6,
+				Viscosity:        0.005,
+				Damping:          0.96,
+				InteractionRad:   3,
+				SurfaceTension:   0.05,
+				AmbientTemp:      20,
+				Conductivity:     0.1,
+				CoolingRate:      0.004,
+				ThermalViscosity: 0.1,
+				SpawnCount:       20,
+				PaletteName:      "Magma",
+				SpawnSpecies:     "Magma",
+				IsPaused:         false,
+			},
+			"Honey": {
+				Gravity:         0.04,
+				Stiffness:       0.06,
+				StiffnessNear:   0.08,
+				RestDensity:     4,
+				Viscosity:       0.04,
+				Damping:         0.9,
+				InteractionRad:  3,
+				SurfaceTension:  0.05,
+				SpringStiffness: 0.1,
+				YieldRatio:      0.2,
+				Plasticity:      0.3,
+				SpawnCount:      20,
+				PaletteName:     "Beer",
+				IsPaused:        false,
+			},
+			"Jelly": {
+				Gravity:           0.05,
+				Stiffness:         0.07,
+				StiffnessNear:     0.08,
+				RestDensity:       3,
+				Viscosity:         0.02,
+				Damping:           0.93,
+				InteractionRad:    3,
+				SpringStiffness:   0.3,
+				YieldRatio:        0.1,
+				Plasticity:        0,
+				BorderRestitution: 0.5,
+				SpawnCount:        20,
+				PaletteName:       "Nebula",
+				IsPaused:          false,
+			},
+			"River": {
+				Gravity:        0.05,
+				GravityAngle:   10,
+				Stiffness:      0.07,
+				StiffnessNear:  0.08,
+				RestDensity:    3.0,
+				Viscosity:      0.02,
+				Damping:        0.91,
+				InteractionRad: 3,
+				EdgeLeft:       EdgePeriodic,
+				EdgeRight:      EdgePeriodic,
+				SpawnCount:     20,
+				PaletteName:    "Water",
+				IsPaused:       false,
+			},
+			"Waterfall": {
+				Gravity:        0.05,
+				Stiffness:      0.07,
+				StiffnessNear:  0.08,
+				RestDensity:    3.0,
+				Viscosity:      0.02,
+				Damping:        0.91,
+				InteractionRad: 3,
+				EdgeBottom:     EdgeOpen,
+				SpawnCount:     20,
+				PaletteName:    "Water",
+				IsPaused:       false,
+			},
+			"Slime": {
+				Gravity:        0.04,
 				Stiffness:      0.06,
 				StiffnessNear:  0.08,
-				RestDensity:    6,
-				Viscosity:      0.005,
-				Damping:        0.96,
+				RestDensity:    4,
+				Viscosity:      0.04,
+				Damping:        0.9,
 				InteractionRad: 3,
+				SurfaceTension: 0.1,
+				Adhesion:       0.05,
+				WallFriction:   0.5,
+				BorderFriction: 0.5,
 				SpawnCount:     20,
-				PaletteName:    "Magma",
+				PaletteName:    "Slime",
 				IsPaused:       false,
 			},
 		},
@@ -169,7 +360,20 @@ func NewDefaultConfig() *AppConfig {
 					"#F0FFFF", "#AFEEEE", "#00CED1", "#008B8B", "#003333",
 				},
 			},
+			{
+				Name: "Rock",
+				Colors: []string{
+					"#BFBFBF", "#8C8C8C", "#5E5E5E", "#3A3A3A", "#1C1C1C",
+				},
+			},
+			{
+				Name: "Steam",
+				Colors: []string{
+					"#FFFFFF", "#EEEEEE", "#D0D0D0", "#B0B0B0", "#909090",
+				},
+			},
 		},
+		Species: DefaultSpecies(),
 	}
 
 	for k, v := range cfg.Presets {
@@ -179,3 +383,16 @@ func NewDefaultConfig() *AppConfig {
 
 	return cfg
 }
+
+func DefaultSpecies() []Species {
+	return []Species{
+		{Name: "Fluid", Mass: 1.0},
+		{Name: "Oil", Mass: 0.5, Viscosity: 0.04, PaletteName: "Oil"},
+		{Name: "Syrup", Mass: 1.5, Viscosity: 0.15, PaletteName: "Beer"},
+		{Name: "Water", Mass: 1.0, PaletteName: "Water", CoolBelow: 0, CoolInto: "Ice", HeatAbove: 100, HeatInto: "Steam"},
+		{Name: "Steam", Mass: 0.3, Viscosity: 0.005, PaletteName: "Steam", Temperature: 110, Lift: 2, CoolBelow: 90, CoolInto: "Water"},
+		{Name: "Ice", Mass: 1.0, PaletteName: "Glacier", Temperature: -10, Static: true, HeatAbove: 5, HeatInto: "Water"},
+		{Name: "Magma", Mass: 1.5, Viscosity: 0.03, PaletteName: "Magma", Temperature: 1200, CoolBelow: 700, CoolInto: "Rock"},
+		{Name: "Rock", Mass: 1.5, PaletteName: "Rock", Temperature: 600, Static: true, HeatAbove: 1000, HeatInto: "Magma"},
+	}
+}
@@ -0,0 +1,109 @@
+package headless
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/config"
+	"github.com/null-enjoyer/terminal-fluid-simulation/simulation"
+)
+
+// BenchCounts are the particle counts the layout benchmark runs with
+var BenchCounts = []int{10000, 25000, 45000}
+
+// benchWarmup frames run before the timing starts, the first ones include the sort
+const benchWarmup = 10
+
+type benchLayout struct {
+	name         string
+	sortInterval int
+	gather       bool
+}
+
+// benchLayouts are compared against the first one, particles kept in spawn order
+var benchLayouts = []benchLayout{
+	{name: "unsorted"},
+	{name: "sorted", sortInterval: simulation.DefaultSortInterval},
+	{name: "sorted_gather", sortInterval: simulation.DefaultSortInterval, gather: true},
+}
+
+type BenchResult struct {
+	Particles    int     `json:"particles"`
+	Layout       string  `json:"layout"`
+	Width        int     `json:"width"`
+	Height       int     `json:"height"`
+	MeanCalcTime float64 `json:"mean_calc_us"`
+	Speedup      float64 `json:"speedup"` // unsorted time divided by this one, for the same particle count
+}
+
+type BenchReport struct {
+	Preset  string        `json:"preset"`
+	Workers int           `json:"workers"`
+	Frames  int           `json:"frames"`
+	Results []BenchResult `json:"results"`
+}
+
+// Bench times the particle storage layouts on a settling block of fluid for every count
+// in BenchCounts and writes a JSON report to out. The particles start in random order,
+// like after a long session of spawning and draining, so the unsorted layout shows the
+// cost of scattered neighbor reads.
+func Bench(appConfig *config.AppConfig, opts Options, out io.Writer) error {
+	cfg, ok := appConfig.Presets[opts.Preset]
+	if !ok {
+		return fmt.Errorf("preset %q not found", opts.Preset)
+	}
+	if opts.Seed != 0 {
+		cfg.Seed = opts.Seed
+	}
+	height := opts.Height
+	if height == 0 {
+		height = 100
+	}
+
+	report := BenchReport{Preset: opts.Preset, Frames: opts.Frames}
+	for _, count := range BenchCounts {
+		baseline := 0.0
+		for _, layout := range benchLayouts {
+			sim := simulation.NewSimulation(1, 1, cfg, appConfig.Species)
+			if opts.Workers > 0 {
+				sim.SetWorkers(opts.Workers)
+			} else if appConfig.Workers > 0 {
+				sim.SetWorkers(appConfig.Workers)
+			}
+			sim.MaxParticles = 0 // the counts are not capped
+			sim.SortInterval = layout.sortInterval
+			sim.GatherPositions = layout.gather
+			width := sim.FillBlock(count, height)
+			sim.Config.IsPaused = false
+			report.Workers = sim.Pool.Workers
+
+			for frame := 0; frame < benchWarmup; frame++ {
+				sim.Step()
+			}
+			total := int64(0)
+			for frame := 0; frame < opts.Frames; frame++ {
+				total += sim.Step().Microseconds()
+			}
+			sim.Close()
+
+			mean := float64(total) / math.Max(float64(opts.Frames), 1)
+			if baseline == 0 {
+				baseline = mean
+			}
+			report.Results = append(report.Results, BenchResult{
+				Particles:    count,
+				Layout:       layout.name,
+				Width:        width,
+				Height:       height,
+				MeanCalcTime: mean,
+				Speedup:      baseline / math.Max(mean, 1),
+			})
+		}
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
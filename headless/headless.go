@@ -0,0 +1,244 @@
+package headless
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/config"
+	"github.com/null-enjoyer/terminal-fluid-simulation/replay"
+	"github.com/null-enjoyer/terminal-fluid-simulation/scene"
+	"github.com/null-enjoyer/terminal-fluid-simulation/simulation"
+)
+
+const (
+	DefaultWidth  = 120
+	DefaultHeight = 40
+)
+
+type Options struct {
+	Width, Height   int // zero uses the scene size or the defaults
+	Frames          int
+	Preset          string
+	ScenePath       string
+	ScriptPath      string
+	Workers         int   // overrides the config file when > 0
+	Seed            int64 // overrides the preset and scene seed when non-zero
+	ReplayPath      string
+	GatherPositions bool    // grid ordered position copies for the neighbor search, see Simulation.GatherPositions
+	MaxParticles    int     // overrides the config file when non-zero, negative is unlimited
+	PhysicsRate     float64 // overrides the config file when > 0, sets the step length
+}
+
+type FrameStats struct {
+	Frame     int   `json:"frame"`
+	Particles int   `json:"particles"`
+	CalcTime  int64 `json:"calc_us"`
+	SubSteps  int   `json:"substeps"`
+}
+
+type Summary struct {
+	Frames       int     `json:"frames"`
+	Particles    int     `json:"particles"`
+	TotalTime    float64 `json:"total_ms"`
+	MeanCalcTime float64 `json:"mean_calc_us"`
+	MinCalcTime  int64   `json:"min_calc_us"`
+	MaxCalcTime  int64   `json:"max_calc_us"`
+	P95CalcTime  int64   `json:"p95_calc_us"`
+	MeanSubSteps float64 `json:"mean_substeps"`
+	MaxSubSteps  int     `json:"max_substeps"`
+	MeanSpeed    float64 `json:"mean_speed"`
+	MaxSpeed     float64 `json:"max_speed"`
+	Checksum     string  `json:"checksum"` // hash of the final particle state, equal for identical seeded runs
+}
+
+type Report struct {
+	Width   int          `json:"width"`
+	Height  int          `json:"height"`
+	Preset  string       `json:"preset"`
+	Workers int          `json:"workers"`
+	Seed    int64        `json:"seed,omitempty"`
+	Frames  []FrameStats `json:"frames"`
+	Summary Summary      `json:"summary"`
+}
+
+// Run steps the simulation without a terminal and writes a JSON report to out
+func Run(appConfig *config.AppConfig, opts Options, out io.Writer) error {
+	cfg, ok := appConfig.Presets[opts.Preset]
+	if !ok {
+		return fmt.Errorf("preset %q not found", opts.Preset)
+	}
+
+	var sc *scene.Scene
+	if opts.ScenePath != "" {
+		var err error
+		if sc, err = scene.Load(opts.ScenePath); err != nil {
+			return err
+		}
+	}
+
+	var rec *replay.Recording
+	if opts.ReplayPath != "" {
+		var err error
+		if rec, err = replay.Load(opts.ReplayPath); err != nil {
+			return err
+		}
+	}
+
+	var actions []Action
+	if opts.ScriptPath != "" {
+		var err error
+		if actions, err = LoadScript(opts.ScriptPath); err != nil {
+			return err
+		}
+	}
+
+	width, height := opts.Width, opts.Height
+	if sc != nil {
+		if width == 0 {
+			width = sc.Width
+		}
+		if height == 0 {
+			height = sc.Height
+		}
+	}
+	if width == 0 {
+		width = DefaultWidth
+	}
+	if height == 0 {
+		height = DefaultHeight
+	}
+
+	if opts.Seed != 0 {
+		cfg.Seed = opts.Seed
+	}
+
+	sim := simulation.NewSimulation(width, height, cfg, appConfig.Species)
+	defer sim.Close()
+
+	workers := appConfig.Workers
+	if opts.Workers > 0 {
+		workers = opts.Workers
+	}
+	if workers > 0 {
+		sim.SetWorkers(workers)
+	}
+	sim.GatherPositions = opts.GatherPositions
+	maxParticles := appConfig.MaxParticles
+	if opts.MaxParticles != 0 {
+		maxParticles = opts.MaxParticles
+	}
+	if maxParticles != 0 {
+		sim.MaxParticles = maxParticles
+	}
+	sim.StepRate = appConfig.PhysicsRate
+	if opts.PhysicsRate > 0 {
+		sim.StepRate = opts.PhysicsRate
+	}
+	if sc != nil {
+		sc.Apply(sim)
+		if opts.Seed != 0 {
+			sim.Config.Seed = opts.Seed
+			sim.Reseed(opts.Seed)
+		}
+	}
+	if rec != nil {
+		rec.Start(sim)
+	}
+	// a batch run never waits for the user to unpause, a replay keeps its recorded state
+	if rec == nil {
+		sim.Config.IsPaused = false
+	}
+
+	report := Report{
+		Width:   sim.Width,
+		Height:  sim.Height,
+		Preset:  opts.Preset,
+		Workers: sim.Pool.Workers,
+		Seed:    sim.Config.Seed,
+		Frames:  make([]FrameStats, 0, opts.Frames),
+	}
+
+	start := time.Now()
+	for frame := 0; frame < opts.Frames; frame++ {
+		for _, a := range actions {
+			if frame >= a.From && frame <= a.To {
+				sim.Apply(a.Command())
+			}
+		}
+		sim.ProcessCommands()
+
+		calcTime := sim.Step()
+		report.Frames = append(report.Frames, FrameStats{
+			Frame:     frame,
+			Particles: len(sim.Particles),
+			CalcTime:  calcTime.Microseconds(),
+			SubSteps:  sim.SubStepCount(),
+		})
+	}
+
+	report.Summary = summarize(sim, report.Frames)
+	report.Summary.TotalTime = float64(time.Since(start).Microseconds()) / 1000.0
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+func summarize(sim *simulation.Simulation, frames []FrameStats) Summary {
+	sum := Summary{
+		Frames:    len(frames),
+		Particles: len(sim.Particles),
+	}
+
+	if len(frames) > 0 {
+		times := make([]int64, len(frames))
+		total := int64(0)
+		for i, f := range frames {
+			times[i] = f.CalcTime
+			total += f.CalcTime
+		}
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+		sum.MeanCalcTime = float64(total) / float64(len(times))
+		sum.MinCalcTime = times[0]
+		sum.MaxCalcTime = times[len(times)-1]
+		sum.P95CalcTime = times[(len(times)*95)/100]
+
+		for _, f := range frames {
+			sum.MeanSubSteps += float64(f.SubSteps)
+			sum.MaxSubSteps = max(sum.MaxSubSteps, f.SubSteps)
+		}
+		sum.MeanSubSteps /= float64(len(frames))
+	}
+
+	for _, p := range sim.Particles {
+		vx := p.Pos.X - p.OldPos.X
+		vy := p.Pos.Y - p.OldPos.Y
+		speed := math.Sqrt(vx*vx + vy*vy)
+		sum.MeanSpeed += speed
+		if speed > sum.MaxSpeed {
+			sum.MaxSpeed = speed
+		}
+	}
+	if len(sim.Particles) > 0 {
+		sum.MeanSpeed /= float64(len(sim.Particles))
+	}
+
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, p := range sim.Particles {
+		for _, v := range [4]float64{p.Pos.X, p.Pos.Y, p.OldPos.X, p.OldPos.Y} {
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+			h.Write(buf[:])
+		}
+	}
+	sum.Checksum = fmt.Sprintf("%016x", h.Sum64())
+
+	return sum
+}
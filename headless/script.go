@@ -0,0 +1,186 @@
+package headless
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/simulation"
+)
+
+// Action is one line of a spawn script:
+//
+//	<frame>[-<frame>] spawn <x> <y> [species]
+//	<frame>[-<frame>] wall <x> <y> [material]
+//	<frame>[-<frame>] erase <x> <y>
+//	<frame>[-<frame>] hot <x> <y>
+//	<frame>[-<frame>] cold <x> <y>
+//	<frame> emit <x> <y> <rate> <angle> <speed> [species]
+//	<frame> drain <x> <y> <w> <h>
+//	<frame> box <x> <y> <w> <h> <density>
+//	<frame> circle <x> <y> <radius> <density>
+//
+// A frame range repeats the action on every frame of the range.
+type Action struct {
+	From, To int
+	Kind     string
+	X, Y     float64
+	Species  int
+	Material simulation.WallMaterial
+	Params   []float64 // emitter rate, angle and speed, drain size or body size and density
+}
+
+func (a Action) Command() simulation.Command {
+	switch a.Kind {
+	case "wall", "erase":
+		return simulation.Command{Kind: simulation.CmdSetWall, X: a.X, Y: a.Y, Width: 1, Height: 1, On: a.Kind == "wall", Material: a.Material}
+	case "hot", "cold":
+		heat := simulation.HeatHot
+		if a.Kind == "cold" {
+			heat = simulation.HeatCold
+		}
+		return simulation.Command{Kind: simulation.CmdSetWall, X: a.X, Y: a.Y, Width: 1, Height: 1, On: true, Heat: heat}
+	case "emit":
+		return simulation.Command{
+			Kind:   simulation.CmdAddSource,
+			X:      a.X,
+			Y:      a.Y,
+			Width:  1,
+			Height: 1,
+			Source: &simulation.Source{
+				Kind:    simulation.SourceEmitter,
+				Species: a.Species,
+				Rate:    a.Params[0],
+				Angle:   a.Params[1],
+				Speed:   a.Params[2],
+			},
+		}
+	case "drain":
+		return simulation.Command{
+			Kind:   simulation.CmdAddSource,
+			X:      a.X,
+			Y:      a.Y,
+			Width:  int(a.Params[0]),
+			Height: int(a.Params[1]),
+			Source: &simulation.Source{Kind: simulation.SourceDrain},
+		}
+	case "box", "circle":
+		body := simulation.NewCircle(0, 0, a.Params[0], a.Params[1])
+		if a.Kind == "box" {
+			body = simulation.NewBox(0, 0, a.Params[0], a.Params[1], a.Params[2])
+		}
+		return simulation.Command{Kind: simulation.CmdAddBody, X: a.X, Y: a.Y, Body: &body}
+	default:
+		return simulation.Command{Kind: simulation.CmdSpawn, X: a.X, Y: a.Y, Species: a.Species}
+	}
+}
+
+func LoadScript(path string) ([]Action, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var actions []Action
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		action, err := parseAction(strings.Fields(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+		actions = append(actions, action)
+	}
+
+	return actions, scanner.Err()
+}
+
+func parseAction(fields []string) (Action, error) {
+	var a Action
+	if len(fields) < 4 {
+		return a, fmt.Errorf("expected '<frame> <action> <x> <y>'")
+	}
+
+	from, to, found := strings.Cut(fields[0], "-")
+	var err error
+	if a.From, err = strconv.Atoi(from); err != nil {
+		return a, fmt.Errorf("invalid frame %q", fields[0])
+	}
+	a.To = a.From
+	if found {
+		if a.To, err = strconv.Atoi(to); err != nil || a.To < a.From {
+			return a, fmt.Errorf("invalid frame range %q", fields[0])
+		}
+	}
+
+	a.Kind = fields[1]
+	switch a.Kind {
+	case "spawn", "wall", "erase", "hot", "cold", "emit", "drain", "box", "circle":
+	default:
+		return a, fmt.Errorf("unknown action %q", a.Kind)
+	}
+
+	if a.X, err = strconv.ParseFloat(fields[2], 64); err != nil {
+		return a, fmt.Errorf("invalid x %q", fields[2])
+	}
+	if a.Y, err = strconv.ParseFloat(fields[3], 64); err != nil {
+		return a, fmt.Errorf("invalid y %q", fields[3])
+	}
+
+	speciesField := 4
+	switch a.Kind {
+	case "emit":
+		speciesField = 7
+		if err := parseParams(&a, fields, 3); err != nil {
+			return a, err
+		}
+	case "drain", "circle":
+		if err := parseParams(&a, fields, 2); err != nil {
+			return a, err
+		}
+	case "box":
+		if err := parseParams(&a, fields, 3); err != nil {
+			return a, err
+		}
+	}
+
+	if (a.Kind == "spawn" || a.Kind == "emit") && len(fields) > speciesField {
+		if a.Species, err = strconv.Atoi(fields[speciesField]); err != nil {
+			return a, fmt.Errorf("invalid species %q", fields[speciesField])
+		}
+	}
+
+	if a.Kind == "wall" && len(fields) > 4 {
+		var ok bool
+		if a.Material, ok = simulation.ParseWallMaterial(fields[4]); !ok {
+			return a, fmt.Errorf("unknown wall material %q", fields[4])
+		}
+	}
+
+	return a, nil
+}
+
+// parseParams reads n numbers following the position
+func parseParams(a *Action, fields []string, n int) error {
+	if len(fields) < 4+n {
+		return fmt.Errorf("%s expects %d values after the position", a.Kind, n)
+	}
+	a.Params = make([]float64, n)
+	for i := range a.Params {
+		v, err := strconv.ParseFloat(fields[4+i], 64)
+		if err != nil {
+			return fmt.Errorf("invalid value %q", fields[4+i])
+		}
+		a.Params[i] = v
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package headless
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/simulation"
+)
+
+func TestParseAction(t *testing.T) {
+	tests := []struct {
+		line string
+		want Action
+	}{
+		{"0 spawn 10 5", Action{Kind: "spawn", X: 10, Y: 5}},
+		{"3-8 spawn 10.5 5 2", Action{From: 3, To: 8, Kind: "spawn", X: 10.5, Y: 5, Species: 2}},
+		{"4 wall 1 2", Action{From: 4, To: 4, Kind: "wall", X: 1, Y: 2}},
+		{"4 wall 1 2 sticky", Action{From: 4, To: 4, Kind: "wall", X: 1, Y: 2, Material: simulation.WallSticky}},
+		{"5-6 erase 1 2", Action{From: 5, To: 6, Kind: "erase", X: 1, Y: 2}},
+		{"0 hot 3 4", Action{Kind: "hot", X: 3, Y: 4}},
+		{"0 cold 3 4", Action{Kind: "cold", X: 3, Y: 4}},
+		{"1 emit 10 5 2 90 1.5", Action{From: 1, To: 1, Kind: "emit", X: 10, Y: 5, Params: []float64{2, 90, 1.5}}},
+		{"1 emit 10 5 2 90 1.5 3", Action{From: 1, To: 1, Kind: "emit", X: 10, Y: 5, Species: 3, Params: []float64{2, 90, 1.5}}},
+		{"2 drain 0 30 10 2", Action{From: 2, To: 2, Kind: "drain", X: 0, Y: 30, Params: []float64{10, 2}}},
+		{"0 box 40 5 8 4 0.6", Action{Kind: "box", X: 40, Y: 5, Params: []float64{8, 4, 0.6}}},
+		{"0 circle 80 5 4 1.5", Action{Kind: "circle", X: 80, Y: 5, Params: []float64{4, 1.5}}},
+	}
+	for _, tt := range tests {
+		got, err := parseAction(strings.Fields(tt.line))
+		if err != nil {
+			t.Errorf("%q: %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseActionErrors(t *testing.T) {
+	tests := []struct {
+		line, err string
+	}{
+		{"0 spawn 10", "expected '<frame> <action> <x> <y>'"},
+		{"x spawn 10 5", `invalid frame "x"`},
+		{"5-2 spawn 10 5", `invalid frame range "5-2"`},
+		{"5- spawn 10 5", `invalid frame range "5-"`},
+		{"0 pour 10 5", `unknown action "pour"`},
+		{"0 Spawn 10 5", `unknown action "Spawn"`},
+		{"0 spawn a 5", `invalid x "a"`},
+		{"0 spawn 10 b", `invalid y "b"`},
+		{"0 spawn 10 5 oil", `invalid species "oil"`},
+		{"0 wall 1 2 glass", `unknown wall material "glass"`},
+		{"0 emit 10 5 2 90", "emit expects 3 values after the position"},
+		{"0 emit 10 5 2 up 1", `invalid value "up"`},
+		{"0 drain 0 30 10", "drain expects 2 values after the position"},
+		{"0 box 40 5 8 4", "box expects 3 values after the position"},
+		{"0 circle 80 5", "circle expects 2 values after the position"},
+	}
+	for _, tt := range tests {
+		_, err := parseAction(strings.Fields(tt.line))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: got error %v, want %q", tt.line, err, tt.err)
+		}
+	}
+}
+
+// TestLoadScript skips blank lines and comments and reports errors with their line
+func TestLoadScript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.txt")
+	script := "# pour and wall\n\n0-9 spawn 10 5\n  12 wall 1 2  \n"
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	actions, err := LoadScript(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Action{
+		{From: 0, To: 9, Kind: "spawn", X: 10, Y: 5},
+		{From: 12, To: 12, Kind: "wall", X: 1, Y: 2},
+	}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("got %+v, want %+v", actions, want)
+	}
+
+	if err := os.WriteFile(path, []byte(script+"20 flood 1 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadScript(path); err == nil || !strings.HasSuffix(err.Error(), `:5: unknown action "flood"`) {
+		t.Errorf("got error %v, want one for line 5", err)
+	}
+}
@@ -3,13 +3,33 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"os"
 
 	"github.com/null-enjoyer/terminal-fluid-simulation/app"
+	"github.com/null-enjoyer/terminal-fluid-simulation/config"
+	"github.com/null-enjoyer/terminal-fluid-simulation/headless"
 )
 
 func main() {
 	configPath := flag.String("config", "", "Path to the settings file (optional)")
+	scenePath := flag.String("scene", "", "Path to a scene file to load at startup (optional)")
+	headlessMode := flag.Bool("headless", false, "Run without a terminal UI and print a JSON report")
+	frames := flag.Int("frames", 600, "Number of frames to simulate in headless mode")
+	width := flag.Int("width", 0, "Simulation width in headless mode (default: scene width or 120)")
+	height := flag.Int("height", 0, "Simulation height in headless mode (default: scene height or 40)")
+	preset := flag.String("preset", "Default", "Preset used in headless mode")
+	script := flag.String("script", "", "Spawn script for headless mode (optional)")
+	seed := flag.Int64("seed", 0, "Seed for deterministic, repeatable runs (0 = random, non-deterministic)")
+	record := flag.String("record", "", "Record the session to this file (optional, Ctrl+R toggles recording)")
+	replayPath := flag.String("replay", "", "Replay a recorded session (optional)")
+	workers := flag.Int("workers", 0, "Number of solver worker goroutines (default: config value or one per CPU)")
+	gather := flag.Bool("gather-positions", false, "Copy the positions into grid ordered arrays before each neighbor search")
+	maxParticles := flag.String("max-particles", "", "Particle cap, a count or \"unlimited\" (default: config value or 45000)")
+	physicsRate := flag.Float64("physics-rate", 0, "Simulation steps per second, the simulated time runs at the same speed at any rate (default: config value or 60)")
+	renderFPS := flag.Int("fps", 0, "Frames drawn per second (default: config value or 60)")
+	rewind := flag.Int("rewind", 0, "Frames kept for rewinding, e.g. 300 (default: config value or off)")
+	bench := flag.Bool("bench", false, "Compare the particle storage layouts at 10k, 25k and 45k particles in headless mode")
 	help := flag.Bool("help", false, "Show this help message")
 
 	flag.Usage = func() {
@@ -24,7 +44,64 @@ func main() {
 		os.Exit(0)
 	}
 
-	application := app.New(*configPath)
+	particleCap := 0
+	if *maxParticles != "" {
+		var err error
+		if particleCap, err = config.ParseMaxParticles(*maxParticles); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	appOpts := app.Options{
+		ConfigPath:      *configPath,
+		ScenePath:       *scenePath,
+		Workers:         *workers,
+		GatherPositions: *gather,
+		MaxParticles:    particleCap,
+		PhysicsRate:     *physicsRate,
+		RenderFPS:       *renderFPS,
+		RewindFrames:    *rewind,
+		Seed:            *seed,
+		RecordPath:      *record,
+		ReplayPath:      *replayPath,
+	}
+
+	if *headlessMode {
+		appConfig := config.NewDefaultConfig()
+		if *configPath != "" {
+			var err error
+			if appConfig, err = config.LoadSettings(*configPath); err != nil {
+				log.Fatalf("Failed to load settings: %v", err)
+			}
+		}
+
+		opts := headless.Options{
+			Width:           *width,
+			Height:          *height,
+			Frames:          *frames,
+			Preset:          *preset,
+			ScenePath:       *scenePath,
+			ScriptPath:      *script,
+			Workers:         *workers,
+			Seed:            *seed,
+			ReplayPath:      *replayPath,
+			GatherPositions: *gather,
+			MaxParticles:    particleCap,
+			PhysicsRate:     *physicsRate,
+		}
+		if *bench {
+			if err := headless.Bench(appConfig, opts, os.Stdout); err != nil {
+				log.Fatalf("Benchmark failed: %v", err)
+			}
+			return
+		}
+		if err := headless.Run(appConfig, opts, os.Stdout); err != nil {
+			log.Fatalf("Headless run failed: %v", err)
+		}
+		return
+	}
+
+	application := app.New(appOpts)
 	defer application.Screen.Fini()
 	application.Run()
 }
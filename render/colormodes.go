@@ -0,0 +1,49 @@
+package render
+
+import "github.com/gdamore/tcell/v2"
+
+// ColorMode picks the per-particle value that is mapped onto the palette.
+// Min and Max are the fixed range used when auto range is off.
+type ColorMode struct {
+	Name     string
+	Min, Max float64
+	Value    func(p *Point) float64 // nil colors by particle count
+	Colors   []tcell.Color          // fixed palette, nil uses the selected one
+}
+
+// HeatColors runs from cold blue over white to glowing red
+var HeatColors = []tcell.Color{
+	tcell.NewRGBColor(40, 60, 200),
+	tcell.NewRGBColor(80, 140, 230),
+	tcell.NewRGBColor(170, 210, 250),
+	tcell.NewRGBColor(240, 240, 240),
+	tcell.NewRGBColor(250, 220, 120),
+	tcell.NewRGBColor(250, 160, 40),
+	tcell.NewRGBColor(230, 80, 20),
+	tcell.NewRGBColor(190, 20, 10),
+}
+
+var ColorModes = []ColorMode{
+	{Name: "Occupancy"},
+	{Name: "Speed", Min: 0, Max: 1, Value: func(p *Point) float64 { return float64(p.Speed) }},
+	{Name: "Density", Min: 0, Max: 8, Value: func(p *Point) float64 { return float64(p.Density) }},
+	{Name: "NearDens", Min: 0, Max: 4, Value: func(p *Point) float64 { return float64(p.NearDensity) }},
+	{Name: "Pressure", Min: -0.1, Max: 0.3, Value: func(p *Point) float64 { return float64(p.Pressure) }},
+	{Name: "Temp", Min: -20, Max: 1200, Value: func(p *Point) float64 { return float64(p.Temp) }, Colors: HeatColors},
+}
+
+// PaletteIndex maps v within min..max onto a palette of n colors
+func PaletteIndex(v, min, max float64, n int) int {
+	if max <= min || n <= 1 {
+		return 0
+	}
+	t := (v - min) / (max - min)
+	idx := int(t * float64(n))
+	if idx < 0 {
+		return 0
+	}
+	if idx >= n {
+		return n - 1
+	}
+	return idx
+}
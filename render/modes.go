@@ -0,0 +1,98 @@
+package render
+
+import "github.com/gdamore/tcell/v2"
+
+// Mode maps ScaleX x ScaleY simulation cells onto one terminal cell
+type Mode struct {
+	Name   string
+	ScaleX int
+	ScaleY int
+	glyphs []rune // indexed by the mask of lit sub-cells, nil draws Braille dots
+}
+
+var Modes = []Mode{
+	{Name: "Block", ScaleX: 1, ScaleY: 1, glyphs: []rune{' ', '█'}},
+	{Name: "HalfBlock", ScaleX: 1, ScaleY: 2, glyphs: []rune{' ', '▀', '▄', '█'}},
+	{Name: "Quadrant", ScaleX: 2, ScaleY: 2, glyphs: []rune{
+		' ', '▘', '▝', '▀', '▖', '▌', '▞', '▛', '▗', '▚', '▐', '▜', '▄', '▙', '▟', '█',
+	}},
+	{Name: "Braille", ScaleX: 2, ScaleY: 4},
+}
+
+// braille dot bits for sub-cells in row-major order
+var brailleBits = [8]rune{0x01, 0x08, 0x02, 0x10, 0x04, 0x20, 0x40, 0x80}
+
+// Empty marks a sub-cell without content
+const Empty = tcell.ColorDefault
+
+type Cell struct {
+	Rune  rune
+	Style tcell.Style
+}
+
+func ModeIndex(name string) int {
+	for i, m := range Modes {
+		if m.Name == name {
+			return i
+		}
+	}
+	return 0
+}
+
+// Compose turns the colors of one terminal cell's sub-cells (row-major) into a glyph.
+// Block modes use the most common color as foreground and the runner-up as background,
+// Braille can only color its dots.
+func (m Mode) Compose(pixels []tcell.Color) Cell {
+	fg := dominant(pixels, Empty)
+	if fg == Empty {
+		return Cell{Rune: ' ', Style: tcell.StyleDefault}
+	}
+
+	if m.glyphs == nil {
+		r := rune(0x2800)
+		for i, c := range pixels {
+			if c != Empty {
+				r |= brailleBits[i]
+			}
+		}
+		return Cell{Rune: r, Style: tcell.StyleDefault.Foreground(fg)}
+	}
+
+	mask := 0
+	hasEmpty := false
+	for i, c := range pixels {
+		if c == fg {
+			mask |= 1 << i
+		} else if c == Empty {
+			hasEmpty = true
+		}
+	}
+
+	style := tcell.StyleDefault.Foreground(fg)
+	if !hasEmpty {
+		if bg := dominant(pixels, fg); bg != Empty {
+			style = style.Background(bg)
+		}
+	}
+	return Cell{Rune: m.glyphs[mask], Style: style}
+}
+
+// dominant returns the most common color that is neither empty nor skip
+func dominant(pixels []tcell.Color, skip tcell.Color) tcell.Color {
+	best, bestCount := Empty, 0
+	for i, c := range pixels {
+		if c == Empty || c == skip || c == best {
+			continue
+		}
+		count := 0
+		for _, o := range pixels[i:] {
+			if o == c {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = c, count
+		}
+	}
+	return best
+}
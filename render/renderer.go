@@ -4,9 +4,76 @@ import "time"
 
 type FrameSnapshot struct {
 	Points   []Point
-	CalcTime time.Duration
+	Sources  []Source
+	Bodies   []Body
+	Walls    *Walls
+	CalcTime time.Duration // solver time of the steps since the last frame
+
+	Frame         uint64
+	Width, Height int // simulation domain
+	MaxParticles  int // particle cap, zero or less is unlimited
+	StepInterval  time.Duration
+	TimeScale     float64
+	SubSteps      int // substeps of the last step
+	Simulated     uint64
+	SubStep       int // substeps of the current frame already run while paused
+	Rewind        int // frames the state is behind the newest one of the rewind buffer
+	RewindLen     int
+
+	Recording bool
+	Replaying bool
+	RecordErr error // the write error that ended the recording, until the app stops it
+}
+
+// Walls is a copy of the wall cells of the simulation and is never changed once sent.
+// Frames share it until the walls change, which also changes Version.
+type Walls struct {
+	Version       uint64
+	Width, Height int
+	Solid         []bool
+	Heat          []int8 // hot and cold marks
+	Materials     []int8
+}
+
+// Source is an emitter or drain block in simulation cells
+type Source struct {
+	X, Y          int
+	Width, Height int
+	Drain         bool
+	Angle         float64 // emitter direction in degrees
+}
+
+// Body is the outline of a rigid body in simulation cells
+type Body struct {
+	Outline []Vertex
+	Density float64
+	Held    bool // dragged by the cursor
+}
+
+type Vertex struct {
+	X, Y float64
+}
+
+// Contains reports whether x, y lies inside the outline
+func (b *Body) Contains(x, y float64) bool {
+	inside := false
+	n := len(b.Outline)
+	for i, j := 0, n-1; i < n; j, i = i, i+1 {
+		a, c := b.Outline[i], b.Outline[j]
+		if (a.Y > y) != (c.Y > y) && x < (c.X-a.X)*(y-a.Y)/(c.Y-a.Y)+a.X {
+			inside = !inside
+		}
+	}
+	return inside
 }
 
 type Point struct {
-	X, Y int
+	X, Y    int
+	Species int
+
+	Speed       float32
+	Density     float32
+	NearDensity float32
+	Pressure    float32
+	Temp        float32
 }
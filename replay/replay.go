@@ -0,0 +1,146 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/config"
+	"github.com/null-enjoyer/terminal-fluid-simulation/simulation"
+)
+
+const Version = 1
+
+// Header is the first line of a recording, every following line is one command
+type Header struct {
+	Version int                  `json:"version"`
+	Frame   uint64               `json:"frame"`
+	Seed    int64                `json:"seed"`
+	Config  config.PhysicsConfig `json:"config"`
+	Species []config.Species     `json:"species"`
+	State   simulation.State     `json:"state"`
+
+	// the step length depends on both, zero is the default rate and normal speed
+	StepRate  float64 `json:"step_rate,omitempty"`
+	TimeScale float64 `json:"time_scale,omitempty"`
+	// rewinds only repeat with the same buffer depth
+	RewindFrames int `json:"rewind_frames,omitempty"`
+}
+
+type Writer struct {
+	file *os.File
+	buf  *bufio.Writer
+	enc  *json.Encoder
+}
+
+func Create(path string) (*Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bufio.NewWriter(file)
+	return &Writer{file: file, buf: buf, enc: json.NewEncoder(buf)}, nil
+}
+
+// Start writes the header and records every further command, must be called from the
+// simulation goroutine. Seed 0 keeps the config seed or picks a new one.
+func (w *Writer) Start(s *simulation.Simulation, seed int64) error {
+	if seed == 0 {
+		seed = s.Config.Seed
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	s.LockSeed(seed)
+
+	header := Header{
+		Version:      Version,
+		Frame:        s.Frame,
+		Seed:         seed,
+		StepRate:     s.StepRate,
+		TimeScale:    s.TimeScale,
+		Config:       s.Config,
+		RewindFrames: s.RewindFrames,
+		Species:      s.Species,
+		State:        *s.CaptureState(),
+	}
+	if err := w.enc.Encode(header); err != nil {
+		return err
+	}
+
+	s.StartRecording(w, seed)
+	return nil
+}
+
+func (w *Writer) Record(cmd simulation.Command) error {
+	return w.enc.Encode(cmd)
+}
+
+func (w *Writer) Close() error {
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+type Recording struct {
+	Header   Header
+	Commands []simulation.Command
+}
+
+func Load(path string) (*Recording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(file))
+
+	var rec Recording
+	if err := dec.Decode(&rec.Header); err != nil {
+		return nil, fmt.Errorf("invalid recording header: %v", err)
+	}
+	if rec.Header.Version < 1 || rec.Header.Version > Version {
+		return nil, fmt.Errorf("unsupported recording version %d", rec.Header.Version)
+	}
+
+	for {
+		var cmd simulation.Command
+		if err := dec.Decode(&cmd); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid command %d: %v", len(rec.Commands)+1, err)
+		}
+		rec.Commands = append(rec.Commands, cmd)
+	}
+
+	rec.Header.Config.UpdateDerived()
+	return &rec, nil
+}
+
+// Start restores the recorded initial state and queues the commands, must be called
+// from the simulation goroutine or before it runs
+func (r *Recording) Start(s *simulation.Simulation) {
+	h := r.Header
+	if len(h.Species) > 0 {
+		s.Species = h.Species
+	}
+
+	s.Resize(h.State.Width, h.State.Height)
+	s.Frame = h.Frame
+	s.StepRate = h.StepRate
+	s.TimeScale = simulation.ClampTimeScale(h.TimeScale)
+	s.RewindFrames = h.RewindFrames
+	s.LockSeed(h.Seed)
+
+	cfg := h.Config
+	state := h.State
+	s.Apply(simulation.Command{Kind: simulation.CmdLoadState, Config: &cfg, State: &state})
+	s.StartReplay(r.Commands)
+}
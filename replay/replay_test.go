@@ -0,0 +1,69 @@
+package replay
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/config"
+	"github.com/null-enjoyer/terminal-fluid-simulation/simulation"
+)
+
+// TestRoundTrip records a seeded session of spawns, walls and frame steps and replays
+// it, both runs have to end in the same state
+func TestRoundTrip(t *testing.T) {
+	const frames = 80
+	cfg := config.NewDefaultConfig().Presets["Default"]
+	cfg.Seed = 3
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+
+	live := simulation.NewSimulation(60, 30, cfg, nil)
+	defer live.Close()
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Start(live, 0); err != nil {
+		t.Fatal(err)
+	}
+	input := map[int][]simulation.Command{
+		2:  {{Kind: simulation.CmdSetWall, X: 10, Y: 20, Width: 30, Height: 2, On: true}},
+		5:  {{Kind: simulation.CmdSpawn, X: 20, Y: 8, Width: 12, Height: 6}},
+		20: {{Kind: simulation.CmdSpawn, X: 35, Y: 6, Species: 1}},
+		30: {{Kind: simulation.CmdStepFrame, Count: 3}},
+		32: {{Kind: simulation.CmdSetWall, X: 12, Y: 20, Width: 4, Height: 2}},
+		40: {{Kind: simulation.CmdPause, On: false}},
+	}
+	for frame := range frames {
+		for _, cmd := range input[frame] {
+			live.Apply(cmd)
+		}
+		live.Step()
+	}
+	if err := live.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := simulation.NewSimulation(1, 1, config.NewDefaultConfig().Presets["Default"], nil)
+	defer replayed.Close()
+	rec.Start(replayed)
+	for range frames {
+		replayed.ProcessCommands()
+		replayed.Step()
+	}
+
+	want, got := live.CaptureState(), replayed.CaptureState()
+	if len(want.Particles) == 0 {
+		t.Fatal("the session spawned no particles")
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("replay ended with %d particles, the session with %d, or their states differ", len(got.Particles), len(want.Particles))
+	}
+}
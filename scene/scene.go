@@ -0,0 +1,65 @@
+package scene
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/config"
+	"github.com/null-enjoyer/terminal-fluid-simulation/simulation"
+)
+
+const Version = 1
+
+type Scene struct {
+	Version int                  `json:"version"`
+	Preset  string               `json:"preset"`
+	Palette string               `json:"palette"`
+	Config  config.PhysicsConfig `json:"config"`
+	simulation.State
+}
+
+// Capture copies the simulation state, must be called from the simulation goroutine
+func Capture(s *simulation.Simulation) *Scene {
+	return &Scene{
+		Version: Version,
+		Config:  s.Config,
+		State:   *s.CaptureState(),
+	}
+}
+
+// Apply loads the scene into the simulation, must be called from the simulation goroutine
+func (sc *Scene) Apply(s *simulation.Simulation) {
+	cfg := sc.Config
+	s.Apply(simulation.Command{Kind: simulation.CmdLoadState, Config: &cfg, State: &sc.State})
+}
+
+func Save(path string, sc *Scene) error {
+	data, err := json.Marshal(sc)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func Load(path string) (*Scene, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sc Scene
+	if err := json.Unmarshal(file, &sc); err != nil {
+		return nil, err
+	}
+
+	if sc.Version < 1 || sc.Version > Version {
+		return nil, fmt.Errorf("unsupported scene version %d", sc.Version)
+	}
+	if sc.Width <= 0 || sc.Height <= 0 {
+		return nil, fmt.Errorf("invalid scene size %dx%d", sc.Width, sc.Height)
+	}
+
+	sc.Config.UpdateDerived()
+	return &sc, nil
+}
@@ -0,0 +1,137 @@
+package scene
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/config"
+	"github.com/null-enjoyer/terminal-fluid-simulation/simulation"
+)
+
+// newScene returns a simulation of the given size with a floor, a wall near the right
+// edge and a spawn of each species in indices
+func newScene(t *testing.T, width, height int, species []config.Species, indices ...int) *simulation.Simulation {
+	t.Helper()
+	sim := simulation.NewSimulation(width, height, config.NewDefaultConfig().Presets["Default"], species)
+	t.Cleanup(sim.Close)
+	for x := range width {
+		sim.SetWall(x, height-1, true)
+	}
+	sim.SetWall(width-2, 3, true)
+	for i, sp := range indices {
+		sim.Spawn(float64(5+i), 5, 1, 1, sp)
+	}
+	return sim
+}
+
+// saveLoad writes the scene to a temporary file and reads it back
+func saveLoad(t *testing.T, sc *Scene) *Scene {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "scene.json")
+	if err := Save(path, sc); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+func TestSaveLoad(t *testing.T) {
+	live := newScene(t, 40, 20, nil, 0, 1, 3)
+	want := live.CaptureState()
+
+	loaded := saveLoad(t, Capture(live))
+	if err := loaded.CheckSpecies(live.Species); err != nil {
+		t.Fatal(err)
+	}
+	sim := simulation.NewSimulation(40, 20, live.Config, nil)
+	defer sim.Close()
+	loaded.Apply(sim)
+
+	if got := sim.CaptureState(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("loaded state differs\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+// TestSpeciesByName loads a scene under a species table in another order, the particles
+// keep their species by name
+func TestSpeciesByName(t *testing.T) {
+	species := config.DefaultSpecies()
+	live := newScene(t, 40, 20, species, 0, 1, 3)
+	loaded := saveLoad(t, Capture(live))
+
+	reversed := make([]config.Species, len(species))
+	for i, sp := range species {
+		reversed[len(species)-1-i] = sp
+	}
+	if err := loaded.CheckSpecies(reversed); err != nil {
+		t.Fatal(err)
+	}
+	sim := simulation.NewSimulation(40, 20, live.Config, reversed)
+	defer sim.Close()
+	loaded.Apply(sim)
+
+	for i, p := range sim.Particles {
+		if got, want := sim.Species[p.Species].Name, live.Species[live.Particles[i].Species].Name; got != want {
+			t.Errorf("particle %d is %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestMissingSpecies(t *testing.T) {
+	species := config.DefaultSpecies()
+	loaded := saveLoad(t, Capture(newScene(t, 40, 20, species, 0, 3)))
+
+	if err := loaded.CheckSpecies(species[1:]); err == nil {
+		t.Errorf("no error for a scene using %s, which is not defined", species[0].Name)
+	}
+	// unused species do not have to be defined
+	if err := loaded.CheckSpecies([]config.Species{species[3], species[0]}); err != nil {
+		t.Error(err)
+	}
+
+	// scenes saved without names use the indices of the table they are loaded with
+	loaded.Species = nil
+	if err := loaded.CheckSpecies(species[:3]); err == nil {
+		t.Error("no error for a species index past the end of the table")
+	}
+	if err := loaded.CheckSpecies(species[:4]); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCrop loads a scene into a smaller domain, walls outside it are cropped and
+// particles are moved inside
+func TestCrop(t *testing.T) {
+	live := newScene(t, 40, 20, nil, 0, 0, 0)
+	live.SetWall(10, 3, true)
+	live.Spawn(35, 15, 1, 1, 0)
+	loaded := saveLoad(t, Capture(live))
+
+	sim := simulation.NewSimulation(30, 10, live.Config, nil)
+	defer sim.Close()
+	loaded.Apply(sim)
+
+	if sim.Width != 30 || sim.Height != 10 || len(sim.Walls) != 30*10 {
+		t.Fatalf("domain is %dx%d with %d cells, want 30x10", sim.Width, sim.Height, len(sim.Walls))
+	}
+	for y := range 10 {
+		for x := range 30 {
+			want := x == 10 && y == 3
+			if sim.Walls[x+y*30] != want {
+				t.Errorf("wall at %d,%d is %v, want %v", x, y, sim.Walls[x+y*30], want)
+			}
+		}
+	}
+	if len(sim.Particles) != len(live.Particles) {
+		t.Fatalf("%d particles, want %d", len(sim.Particles), len(live.Particles))
+	}
+	for i, p := range sim.Particles {
+		if p.Pos.X < 0 || p.Pos.X >= 30 || p.Pos.Y < 0 || p.Pos.Y >= 10 {
+			t.Errorf("particle %d at %v is outside the domain", i, p.Pos)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package simulation
+
+import (
+	"testing"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/config"
+)
+
+// benchLayouts are the particle layouts each step benchmark compares
+var benchLayouts = []struct {
+	name         string
+	sortInterval int
+	gather       bool
+}{
+	{name: "unsorted"},
+	{name: "sorted", sortInterval: DefaultSortInterval},
+	{name: "sorted_gather", sortInterval: DefaultSortInterval, gather: true},
+}
+
+func BenchmarkStep10k(b *testing.B) { benchmarkStep(b, 10000) }
+func BenchmarkStep25k(b *testing.B) { benchmarkStep(b, 25000) }
+func BenchmarkStep45k(b *testing.B) { benchmarkStep(b, 45000) }
+
+// benchmarkStep times a step of count particles in a shuffled block of fluid, the
+// same setup the headless layout benchmark uses
+func benchmarkStep(b *testing.B, count int) {
+	cfg := config.NewDefaultConfig().Presets["Default"]
+	for _, layout := range benchLayouts {
+		b.Run(layout.name, func(b *testing.B) {
+			sim := NewSimulation(1, 1, cfg, nil)
+			defer sim.Close()
+			sim.MaxParticles = 0
+			sim.SortInterval = layout.sortInterval
+			sim.GatherPositions = layout.gather
+			sim.FillBlock(count, 100)
+			sim.Config.IsPaused = false
+			// the first frames include the sort and grow the solver buffers
+			for range 10 {
+				sim.Step()
+			}
+
+			b.ReportAllocs()
+			for b.Loop() {
+				sim.Step()
+			}
+		})
+	}
+}
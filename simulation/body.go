@@ -0,0 +1,749 @@
+package simulation
+
+import (
+	"math"
+	"slices"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/render"
+)
+
+type BodyShape string
+
+const (
+	BodyCircle  BodyShape = "circle"
+	BodyPolygon BodyShape = "polygon"
+)
+
+// MaxBodies bounds the number of rigid bodies, new bodies are skipped once it is reached
+const MaxBodies = 64
+
+const (
+	bodyDamping     = 0.995 // velocity kept per default substep, the fluid provides most of the drag
+	bodySpinDamping = 0.99
+	bodyRestitution = 0.2
+	bodyFriction    = 0.3
+	// maxBodyPush limits how far the particle contacts move a body per substep
+	maxBodyPush = 1.0
+	// dragGain is the fraction of the distance to the cursor a dragged body closes per frame
+	dragGain = 0.5
+	// bodyIterations of the wall and body contact solve per substep
+	bodyIterations = 2
+)
+
+// Body is a rigid circle or convex polygon. X, Y is the center of mass, Points are the
+// polygon vertices relative to it at Angle 0. Velocities are per substep like particle
+// velocities, Spin in radians. Density is the mass per cell, settled fluid holds about
+// one particle per cell so light bodies float and heavy ones sink. Zero or less pins the
+// body in place.
+type Body struct {
+	Shape   BodyShape `json:"shape"`
+	X       float64   `json:"x"`
+	Y       float64   `json:"y"`
+	Angle   float64   `json:"angle,omitempty"`
+	VX      float64   `json:"vx,omitempty"`
+	VY      float64   `json:"vy,omitempty"`
+	Spin    float64   `json:"spin,omitempty"`
+	Radius  float64   `json:"radius,omitempty"`
+	Points  []Vector  `json:"points,omitempty"`
+	Density float64   `json:"density"`
+
+	invMass    float64
+	invInertia float64
+	bound      float64  // distance of the farthest point from the center
+	world      []Vector // vertices in domain coordinates, nil for circles
+	normals    []Vector // outward normals of the edges starting at world
+	samples    []Vector // surface points checked against walls and other bodies
+}
+
+// bodyGrab holds a body at a point while it is dragged
+type bodyGrab struct {
+	body   int
+	local  Vector // grabbed point relative to the body at Angle 0
+	target Vector
+}
+
+// NewBox returns a box centered on x, y
+func NewBox(x, y, width, height, density float64) Body {
+	hw, hh := width/2, height/2
+	return NewPolygon(x, y, []Vector{{-hw, -hh}, {hw, -hh}, {hw, hh}, {-hw, hh}}, density)
+}
+
+func NewCircle(x, y, radius, density float64) Body {
+	return Body{Shape: BodyCircle, X: x, Y: y, Radius: radius, Density: density}
+}
+
+// NewPolygon returns a convex polygon with vertices relative to x, y. The body is
+// centered on the centroid of the outline once it is added.
+func NewPolygon(x, y float64, points []Vector, density float64) Body {
+	return Body{Shape: BodyPolygon, X: x, Y: y, Points: points, Density: density}
+}
+
+// prepare recenters the outline on its centroid and derives the mass properties,
+// it reports false for degenerate shapes
+func (b *Body) prepare() bool {
+	switch b.Shape {
+	case BodyCircle:
+		if !(b.Radius > 0) {
+			return false
+		}
+		b.Points = nil
+		b.world, b.normals = nil, nil
+		mass := math.Pi * b.Radius * b.Radius * b.Density
+		b.setMass(mass, mass*b.Radius*b.Radius/2)
+		b.bound = b.Radius
+
+	case BodyPolygon:
+		n := len(b.Points)
+		if n < 3 {
+			return false
+		}
+		// copied, saved states may share the slice
+		pts := append([]Vector(nil), b.Points...)
+
+		area, cx, cy := 0.0, 0.0, 0.0
+		for i := range pts {
+			a, c := pts[i], pts[(i+1)%n]
+			cross := a.X*c.Y - c.X*a.Y
+			area += cross
+			cx += (a.X + c.X) * cross
+			cy += (a.Y + c.Y) * cross
+		}
+		area /= 2
+		if math.Abs(area) < 1e-6 {
+			return false
+		}
+		cx /= 6 * area
+		cy /= 6 * area
+
+		// positive winding keeps the edge normals pointing outwards
+		if area < 0 {
+			area = -area
+			for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+				pts[i], pts[j] = pts[j], pts[i]
+			}
+		}
+
+		sin, cos := math.Sincos(b.Angle)
+		b.X += cx*cos - cy*sin
+		b.Y += cx*sin + cy*cos
+
+		b.bound = 0
+		inertia := 0.0
+		for i := range pts {
+			pts[i].X -= cx
+			pts[i].Y -= cy
+			b.bound = math.Max(b.bound, math.Hypot(pts[i].X, pts[i].Y))
+		}
+		for i := range pts {
+			a, c := pts[i], pts[(i+1)%n]
+			cross := a.X*c.Y - c.X*a.Y
+			inertia += cross * (a.X*a.X + a.Y*a.Y + a.X*c.X + a.Y*c.Y + c.X*c.X + c.Y*c.Y)
+		}
+		b.Points = pts
+		b.world = make([]Vector, n)
+		b.normals = make([]Vector, n)
+		b.setMass(area*b.Density, inertia*b.Density/12)
+
+	default:
+		return false
+	}
+
+	b.samples = nil
+	b.updateWorld()
+	return true
+}
+
+func (b *Body) setMass(mass, inertia float64) {
+	b.invMass, b.invInertia = 0, 0
+	if mass > 0 && inertia > 0 {
+		b.invMass = 1 / mass
+		b.invInertia = 1 / inertia
+	}
+}
+
+// updateWorld places the outline and the surface samples at the current position and angle
+func (b *Body) updateWorld() {
+	b.samples = b.samples[:0]
+
+	if b.Shape == BodyCircle {
+		n := max(8, int(math.Ceil(2*math.Pi*b.Radius)))
+		for k := 0; k < n; k++ {
+			sin, cos := math.Sincos(b.Angle + 2*math.Pi*float64(k)/float64(n))
+			b.samples = append(b.samples, Vector{X: b.X + b.Radius*cos, Y: b.Y + b.Radius*sin})
+		}
+		return
+	}
+
+	sin, cos := math.Sincos(b.Angle)
+	for i, p := range b.Points {
+		b.world[i] = Vector{X: b.X + p.X*cos - p.Y*sin, Y: b.Y + p.X*sin + p.Y*cos}
+	}
+	n := len(b.world)
+	for i, a := range b.world {
+		c := b.world[(i+1)%n]
+		ex, ey := c.X-a.X, c.Y-a.Y
+		length := math.Hypot(ex, ey)
+		b.normals[i] = Vector{X: ey / length, Y: -ex / length}
+
+		// one sample per cell so no wall cell fits between two of them
+		steps := max(1, int(math.Ceil(length)))
+		for k := 0; k < steps; k++ {
+			t := float64(k) / float64(steps)
+			b.samples = append(b.samples, Vector{X: a.X + ex*t, Y: a.Y + ey*t})
+		}
+	}
+}
+
+// contact reports whether p lies inside the body, with the penetration depth and the
+// surface normal pointing out of the body
+func (b *Body) contact(p Vector) (float64, Vector, bool) {
+	dx, dy := p.X-b.X, p.Y-b.Y
+	distSq := dx*dx + dy*dy
+	if distSq >= b.bound*b.bound {
+		return 0, Vector{}, false
+	}
+
+	if b.Shape == BodyCircle {
+		dist := math.Sqrt(distSq)
+		if dist < 1e-9 {
+			return b.Radius, Vector{X: 0, Y: -1}, true
+		}
+		return b.Radius - dist, Vector{X: dx / dist, Y: dy / dist}, true
+	}
+
+	// the nearest edge of a convex polygon is the one with the largest signed distance
+	best := -math.MaxFloat64
+	var normal Vector
+	for i, v := range b.world {
+		n := b.normals[i]
+		d := (p.X-v.X)*n.X + (p.Y-v.Y)*n.Y
+		if d >= 0 {
+			return 0, Vector{}, false
+		}
+		if d > best {
+			best, normal = d, n
+		}
+	}
+	return -best, normal, true
+}
+
+// velocityAt returns the velocity of the body point at offset r from the center
+func (b *Body) velocityAt(r Vector) Vector {
+	return Vector{X: b.VX - b.Spin*r.Y, Y: b.VY + b.Spin*r.X}
+}
+
+// invMassAt is the inverse mass the body shows to a push along n at offset r
+func (b *Body) invMassAt(r, n Vector) float64 {
+	rn := r.X*n.Y - r.Y*n.X
+	return b.invMass + rn*rn*b.invInertia
+}
+
+func (b *Body) applyImpulse(r Vector, jx, jy float64) {
+	b.VX += jx * b.invMass
+	b.VY += jy * b.invMass
+	b.Spin += (r.X*jy - r.Y*jx) * b.invInertia
+}
+
+// AddBody places a body, degenerate shapes and bodies past MaxBodies are skipped
+func (s *Simulation) AddBody(b Body) {
+	if len(s.Bodies) >= MaxBodies || !b.prepare() {
+		return
+	}
+	s.Bodies = append(s.Bodies, b)
+}
+
+// BodyAt returns the index of the topmost body containing x, y or -1
+func (s *Simulation) BodyAt(x, y float64) int {
+	for i := len(s.Bodies) - 1; i >= 0; i-- {
+		if _, _, ok := s.Bodies[i].contact(Vector{X: x, Y: y}); ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// RemoveBodies deletes every body whose center lies in the block or that covers its center
+func (s *Simulation) RemoveBodies(x, y, width, height int) {
+	center := Vector{X: float64(x) + float64(width)/2, Y: float64(y) + float64(height)/2}
+	n := 0
+	for _, b := range s.Bodies {
+		inBlock := b.X >= float64(x) && b.X < float64(x+width) && b.Y >= float64(y) && b.Y < float64(y+height)
+		if _, _, covers := b.contact(center); inBlock || covers {
+			continue
+		}
+		s.Bodies[n] = b
+		n++
+	}
+	if n != len(s.Bodies) {
+		s.Bodies = s.Bodies[:n]
+		s.grab = nil
+	}
+}
+
+// clone returns a copy with its own vertex buffers, the outline is never changed in place
+func (b Body) clone() Body {
+	b.world = append([]Vector(nil), b.world...)
+	b.normals = append([]Vector(nil), b.normals...)
+	b.samples = append([]Vector(nil), b.samples...)
+	return b
+}
+
+func (s *Simulation) ClearBodies() {
+	s.Bodies = s.Bodies[:0]
+	s.grab = nil
+}
+
+// DragBody grabs the body under x, y and pulls the grabbed point towards x, y on every
+// following call, hold false releases it
+func (s *Simulation) DragBody(x, y float64, hold bool) {
+	if !hold {
+		s.grab = nil
+		return
+	}
+	if s.grab == nil {
+		i := s.BodyAt(x, y)
+		if i < 0 {
+			return
+		}
+		b := &s.Bodies[i]
+		sin, cos := math.Sincos(-b.Angle)
+		dx, dy := x-b.X, y-b.Y
+		s.grab = &bodyGrab{body: i, local: Vector{X: dx*cos - dy*sin, Y: dx*sin + dy*cos}}
+	}
+	s.grab.target = Vector{X: x, Y: y}
+}
+
+func (s *Simulation) setBodies(bodies []Body) {
+	s.ClearBodies()
+	for _, b := range bodies {
+		s.AddBody(b)
+	}
+	s.clampBodies()
+}
+
+// scaleBodies resizes the bodies for a domain rescaled by sx, sy. The rotation is baked
+// into the outline since a rotated polygon does not scale along its own axes.
+func (s *Simulation) scaleBodies(sx, sy float64) {
+	for i := range s.Bodies {
+		b := &s.Bodies[i]
+		b.X *= sx
+		b.Y *= sy
+		b.VX *= sx
+		b.VY *= sy
+		if b.Shape == BodyCircle {
+			b.Radius *= math.Sqrt(sx * sy)
+		} else {
+			pts := make([]Vector, len(b.Points))
+			sin, cos := math.Sincos(b.Angle)
+			for k, p := range b.Points {
+				pts[k] = Vector{X: (p.X*cos - p.Y*sin) * sx, Y: (p.X*sin + p.Y*cos) * sy}
+			}
+			b.Points = pts
+			b.Angle = 0
+		}
+		b.prepare()
+	}
+	s.clampBodies()
+}
+
+// clampBodies moves body centers back into the domain, across periodic edges to the
+// other side
+func (s *Simulation) clampBodies() {
+	limitX := float64(s.Width) - edgeMargin
+	limitY := float64(s.Height) - edgeMargin
+	for i := range s.Bodies {
+		b := &s.Bodies[i]
+		b.X, b.Y = s.wrapPoint(b.X, b.Y)
+		if !s.Config.WrapX {
+			b.X = math.Max(edgeMargin, math.Min(b.X, limitX))
+		}
+		if !s.Config.WrapY {
+			b.Y = math.Max(edgeMargin, math.Min(b.Y, limitY))
+		}
+		b.updateWorld()
+	}
+}
+
+// SolveBodies moves the rigid bodies by one substep and resolves their contacts with
+// particles, walls and each other. The pass is serial, there are few bodies and the
+// particle contacts only look at the grid cells a body covers.
+func (s *Simulation) SolveBodies(dt float64) {
+	if len(s.Bodies) == 0 {
+		return
+	}
+
+	s.integrateBodies(dt)
+	s.pushParticles()
+	for it := 0; it < bodyIterations; it++ {
+		for i := range s.Bodies {
+			for j := i + 1; j < len(s.Bodies); j++ {
+				s.collideBodies(&s.Bodies[i], &s.Bodies[j])
+			}
+		}
+		for i := range s.Bodies {
+			s.collideWalls(&s.Bodies[i])
+		}
+	}
+	if s.hasOpenEdges() {
+		s.dropLeavingBodies()
+	}
+}
+
+// dropLeavingBodies deletes the bodies that left the domain through an open edge
+func (s *Simulation) dropLeavingBodies() {
+	n := 0
+	for i := range s.Bodies {
+		if s.bodyPastOpenEdge(&s.Bodies[i]) {
+			continue
+		}
+		s.Bodies[n] = s.Bodies[i]
+		n++
+	}
+	if n != len(s.Bodies) {
+		s.Bodies = s.Bodies[:n]
+		s.grab = nil
+	}
+}
+
+func (s *Simulation) integrateBodies(dt float64) {
+	accel := substepScale(dt)
+	gx := s.Config.GravityX * accel
+	gy := s.Config.GravityY * accel
+	damping := substepDamping(bodyDamping, dt)
+	spinDamping := substepDamping(bodySpinDamping, dt)
+	maxMove := s.maxMove()
+
+	for i := range s.Bodies {
+		b := &s.Bodies[i]
+
+		if s.grab != nil && s.grab.body == i {
+			sin, cos := math.Sincos(b.Angle)
+			l := s.grab.local
+			px := b.X + l.X*cos - l.Y*sin
+			py := b.Y + l.X*sin + l.Y*cos
+			b.VX = (s.grab.target.X - px) * dragGain * dt
+			b.VY = (s.grab.target.Y - py) * dragGain * dt
+			b.Spin *= 0.9
+		} else if b.invMass == 0 {
+			b.VX, b.VY, b.Spin = 0, 0, 0
+			continue
+		} else {
+			b.VX = b.VX*damping + gx
+			b.VY = b.VY*damping + gy
+			b.Spin *= spinDamping
+		}
+
+		if vSq := b.VX*b.VX + b.VY*b.VY; vSq > maxMove*maxMove {
+			scale := maxMove / math.Sqrt(vSq)
+			b.VX *= scale
+			b.VY *= scale
+		}
+
+		b.X += b.VX
+		b.Y += b.VY
+		b.X, b.Y = s.wrapPoint(b.X, b.Y)
+		b.Angle = math.Remainder(b.Angle+b.Spin, 2*math.Pi)
+		b.updateWorld()
+	}
+}
+
+// pushParticles moves particles out of the bodies. The body takes the opposite push
+// weighted by the masses, which is what makes bodies float and get carried along.
+// Moving a position without its old position changes the velocity as well, the body
+// velocity gets the same change.
+func (s *Simulation) pushParticles() {
+	cols := s.GridCols
+	mats := s.materials
+	wrap := s.seams()
+	wrapped := wrap.any()
+	var colBuf, rowBuf [32]int
+
+	for bi := range s.Bodies {
+		b := &s.Bodies[bi]
+
+		// the hash was built at the start of the substep, particles moved up to a grid cell since
+		gridCols := bodyCells(colBuf[:0], b.X-b.bound, b.X+b.bound, s.Width, cols, s.Config.WrapX)
+		gridRows := bodyCells(rowBuf[:0], b.Y-b.bound, b.Y+b.bound, s.Height, s.GridRows, s.Config.WrapY)
+
+		dx, dy, dAngle := 0.0, 0.0, 0.0
+		for _, gy := range gridRows {
+			row := gy * cols
+			for _, gx := range gridCols {
+				for k := s.CellStart[row+gx]; k < s.CellStart[row+gx+1]; k++ {
+					p := &s.Particles[s.GridOrder[k]]
+					pos := p.Pos
+					if wrapped {
+						// the image of the particle on the side of the body
+						ox, oy := wrap.nearest(pos.X-b.X, pos.Y-b.Y)
+						pos = Vector{X: b.X + ox, Y: b.Y + oy}
+					}
+					depth, n, ok := b.contact(pos)
+					if !ok {
+						continue
+					}
+					depth = math.Min(depth, maxBodyPush)
+
+					r := Vector{X: pos.X - b.X, Y: pos.Y - b.Y}
+					wp := mats[p.Species].invMass
+					if wp > 0 && s.isBlocked(p.Pos.X+n.X*depth, p.Pos.Y+n.Y*depth) {
+						wp = 0
+					}
+					w := wp + b.invMassAt(r, n)
+					if w == 0 {
+						continue
+					}
+					lambda := depth / w
+
+					p.Pos.X += n.X * lambda * wp
+					p.Pos.Y += n.Y * lambda * wp
+					dx -= n.X * lambda * b.invMass
+					dy -= n.Y * lambda * b.invMass
+					dAngle -= (r.X*n.Y - r.Y*n.X) * lambda * b.invInertia
+				}
+			}
+		}
+
+		if dx == 0 && dy == 0 && dAngle == 0 {
+			continue
+		}
+		if d := math.Hypot(dx, dy); d > maxBodyPush {
+			dx *= maxBodyPush / d
+			dy *= maxBodyPush / d
+		}
+		dAngle = math.Max(-0.1, math.Min(dAngle, 0.1))
+
+		b.X += dx
+		b.Y += dy
+		b.Angle += dAngle
+		b.VX += dx
+		b.VY += dy
+		b.Spin += dAngle
+		b.updateWorld()
+	}
+}
+
+// bodyCells appends the grid cells along one axis that hold coordinates within a cell
+// of the span lo to hi, the same reach gridReach gives the fluid. Past a periodic edge
+// the span continues on the other side, past any other edge it is cut off.
+func bodyCells(cells []int, lo, hi float64, size, count int, wrap bool) []int {
+	const cell = 1 << CellShift
+	lo, hi = lo-cell, hi+cell
+	if !wrap {
+		lo, hi = max(lo, 0), min(hi, float64(size)-1)
+		if lo > hi {
+			// left through an open edge
+			return cells
+		}
+	}
+	// cells start at whole coordinates, so every cell the span touches holds one of them
+	for v := math.Floor(lo); v <= hi; v++ {
+		x := v
+		if wrap {
+			x = wrapCoord(v, float64(size))
+		}
+		n := min(int(x)>>CellShift, count-1)
+		if !slices.Contains(cells, n) {
+			cells = append(cells, n)
+		}
+	}
+	return cells
+}
+
+// collideWalls bounces the body off wall cells and the domain margin. The impulses of
+// all touching surface samples are computed from the same velocity and averaged, so the
+// order of the samples does not make resting bodies drift. The deepest sample decides
+// the positional correction.
+func (s *Simulation) collideWalls(b *Body) {
+	if b.invMass == 0 {
+		return
+	}
+
+	s.contacts = s.contacts[:0]
+	deepest := 0.0
+	var fix Vector
+	for _, p := range b.samples {
+		depth, n, ok := s.wallContact(p)
+		if !ok {
+			continue
+		}
+		r := Vector{X: p.X - b.X, Y: p.Y - b.Y}
+		s.contacts = append(s.contacts, contactImpulse(b, nil, r, Vector{}, n))
+		if depth > deepest {
+			deepest = depth
+			fix = Vector{X: n.X * depth, Y: n.Y * depth}
+		}
+	}
+	applyContacts(b, nil, s.contacts)
+
+	if deepest > 0 {
+		b.X += fix.X
+		b.Y += fix.Y
+		b.updateWorld()
+	}
+}
+
+// collideBodies resolves the surface samples of each body that entered the other one
+func (s *Simulation) collideBodies(a, b *Body) {
+	if a.invMass == 0 && b.invMass == 0 {
+		return
+	}
+	dx, dy := b.X-a.X, b.Y-a.Y
+	reach := a.bound + b.bound
+	if dx*dx+dy*dy >= reach*reach {
+		return
+	}
+	s.separateBodies(a, b)
+	s.separateBodies(b, a)
+}
+
+// separateBodies pushes the samples of a out of b
+func (s *Simulation) separateBodies(a, b *Body) {
+	s.contacts = s.contacts[:0]
+	deepest := 0.0
+	var normal Vector
+	for _, p := range a.samples {
+		depth, n, ok := b.contact(p)
+		if !ok {
+			continue
+		}
+		ra := Vector{X: p.X - a.X, Y: p.Y - a.Y}
+		rb := Vector{X: p.X - b.X, Y: p.Y - b.Y}
+		s.contacts = append(s.contacts, contactImpulse(a, b, ra, rb, n))
+		if depth > deepest {
+			deepest, normal = depth, n
+		}
+	}
+	applyContacts(a, b, s.contacts)
+
+	w := a.invMass + b.invMass
+	if deepest == 0 || w == 0 {
+		return
+	}
+	shareA, shareB := deepest*a.invMass/w, deepest*b.invMass/w
+	a.X += normal.X * shareA
+	a.Y += normal.Y * shareA
+	b.X -= normal.X * shareB
+	b.Y -= normal.Y * shareB
+	a.updateWorld()
+	b.updateWorld()
+}
+
+// contact is the impulse on a at offset ra, b takes the opposite one at rb
+type contact struct {
+	ra, rb  Vector
+	impulse Vector
+}
+
+// contactImpulse computes the restitution and friction impulse at a contact with
+// normal n pointing towards a. A nil b is an immovable wall.
+func contactImpulse(a, b *Body, ra, rb, n Vector) contact {
+	c := contact{ra: ra, rb: rb}
+
+	va := a.velocityAt(ra)
+	wn := a.invMassAt(ra, n)
+	t := Vector{X: -n.Y, Y: n.X}
+	wt := a.invMassAt(ra, t)
+	if b != nil {
+		vb := b.velocityAt(rb)
+		va.X -= vb.X
+		va.Y -= vb.Y
+		wn += b.invMassAt(rb, n)
+		wt += b.invMassAt(rb, t)
+	}
+
+	vn := va.X*n.X + va.Y*n.Y
+	if vn >= 0 || wn == 0 {
+		return c
+	}
+	j := -(1 + bodyRestitution) * vn / wn
+
+	vt := va.X*t.X + va.Y*t.Y
+	jt := 0.0
+	if wt > 0 {
+		jt = math.Max(-bodyFriction*j, math.Min(-vt/wt, bodyFriction*j))
+	}
+
+	c.impulse = Vector{X: n.X*j + t.X*jt, Y: n.Y*j + t.Y*jt}
+	return c
+}
+
+// applyContacts applies the mean of the contact impulses
+func applyContacts(a, b *Body, contacts []contact) {
+	if len(contacts) == 0 {
+		return
+	}
+	scale := 1 / float64(len(contacts))
+	for _, c := range contacts {
+		ix, iy := c.impulse.X*scale, c.impulse.Y*scale
+		a.applyImpulse(c.ra, ix, iy)
+		if b != nil {
+			b.applyImpulse(c.rb, -ix, -iy)
+		}
+	}
+}
+
+// wallContact reports whether p lies in a wall or past the margin of a solid edge, with
+// the depth and the direction that leads out of the wall. Across periodic edges the walls
+// of the other side count, past open edges there are none.
+func (s *Simulation) wallContact(p Vector) (float64, Vector, bool) {
+	c := &s.Config
+	depth := 0.0
+	var n Vector
+	if d := edgeMargin - p.X; d > depth && isSolid(c.EdgeLeft, c.WrapX) {
+		depth, n = d, Vector{X: 1}
+	}
+	if d := p.X - (float64(s.Width) - edgeMargin); d > depth && isSolid(c.EdgeRight, c.WrapX) {
+		depth, n = d, Vector{X: -1}
+	}
+	if d := edgeMargin - p.Y; d > depth && isSolid(c.EdgeTop, c.WrapY) {
+		depth, n = d, Vector{Y: 1}
+	}
+	if d := p.Y - (float64(s.Height) - edgeMargin); d > depth && isSolid(c.EdgeBottom, c.WrapY) {
+		depth, n = d, Vector{Y: -1}
+	}
+	if depth > 0 {
+		return depth, n, true
+	}
+
+	if !s.hasWalls {
+		return 0, Vector{}, false
+	}
+	x, y := s.wrapPoint(p.X, p.Y)
+	if x < 0 || x >= float64(s.Width) || y < 0 || y >= float64(s.Height) {
+		return 0, Vector{}, false
+	}
+	dist := s.wallDistance(x, y)
+	if dist >= 0 {
+		return 0, Vector{}, false
+	}
+	// straight up where the field has no slope
+	n, ok := s.wallNormal(x, y)
+	if !ok {
+		n = Vector{X: 0, Y: -1}
+	}
+	return wallSkin - dist, n, true
+}
+
+// AppendBodies copies the body outlines for the renderer. Outlines left in the spare
+// capacity of bodies by an earlier frame are reused.
+func (s *Simulation) AppendBodies(bodies []render.Body) []render.Body {
+	for i := range s.Bodies {
+		b := &s.Bodies[i]
+		outline := b.world
+		if b.Shape == BodyCircle {
+			outline = b.samples
+		}
+		var rb render.Body
+		if n := len(bodies); n < cap(bodies) {
+			rb = bodies[:n+1][n]
+		}
+		rb.Outline = rb.Outline[:0]
+		for _, v := range outline {
+			rb.Outline = append(rb.Outline, render.Vertex{X: v.X, Y: v.Y})
+		}
+		rb.Density = b.Density
+		rb.Held = s.grab != nil && s.grab.body == i
+		bodies = append(bodies, rb)
+	}
+	return bodies
+}
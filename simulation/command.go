@@ -0,0 +1,224 @@
+package simulation
+
+import "github.com/null-enjoyer/terminal-fluid-simulation/config"
+
+type CommandKind string
+
+const (
+	CmdSpawn          CommandKind = "spawn"
+	CmdSetWall        CommandKind = "wall"
+	CmdClearParticles CommandKind = "clear_particles"
+	CmdClearWalls     CommandKind = "clear_walls"
+	CmdConfig         CommandKind = "config"
+	CmdResize         CommandKind = "resize"
+	CmdRescale        CommandKind = "rescale"
+	CmdPause          CommandKind = "pause"
+	CmdLoadState      CommandKind = "load_state"
+	CmdAddSource      CommandKind = "source"
+	CmdForce          CommandKind = "force"
+	CmdAddBody        CommandKind = "body"
+	CmdDragBody       CommandKind = "drag_body"
+	CmdTimeScale      CommandKind = "time_scale"
+	CmdStepFrame      CommandKind = "step_frame"
+	CmdStepSubstep    CommandKind = "step_substep"
+	CmdRewind         CommandKind = "rewind"
+)
+
+// MaxStepFrames bounds the frames a single step command advances
+const MaxStepFrames = 100
+
+// Command is a serializable user action. Frame is stamped by the simulation when the
+// command is applied, replaying a command list at the same frames repeats a session.
+type Command struct {
+	Frame uint64      `json:"frame"`
+	Kind  CommandKind `json:"kind"`
+
+	// spawn position, wall block origin or body position
+	X float64 `json:"x,omitempty"`
+	Y float64 `json:"y,omitempty"`
+
+	// wall block size, spawn area or new domain size
+	Width  int `json:"w,omitempty"`
+	Height int `json:"h,omitempty"`
+
+	Species  int                   `json:"species,omitempty"`
+	On       bool                  `json:"on,omitempty"`       // wall set/erase, pause state, body held
+	Heat     int8                  `json:"heat,omitempty"`     // hot or cold wall block
+	Scale    float64               `json:"scale,omitempty"`    // time scale, 1 is normal speed
+	Count    int                   `json:"count,omitempty"`    // frames to step or rewind, negative rewinds forward
+	Material WallMaterial          `json:"material,omitempty"` // material of a wall block
+	Config   *config.PhysicsConfig `json:"config,omitempty"`
+	State    *State                `json:"state,omitempty"`
+	Source   *Source               `json:"source,omitempty"`
+	Force    *Force                `json:"force,omitempty"` // nil releases the force brush
+	Body     *Body                 `json:"body,omitempty"`
+	// Blocks are further wall blocks set or erased like the one at X, Y, so a large
+	// stroke or fill is a single command
+	Blocks []WallBlock `json:"blocks,omitempty"`
+}
+
+// WallBlock is a block of wall cells, see Command.Blocks
+type WallBlock struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"w"`
+	Height int `json:"h"`
+}
+
+// Recorder receives every command the simulation applies
+type Recorder interface {
+	Record(cmd Command) error
+}
+
+// Apply executes a command on the simulation goroutine
+func (s *Simulation) Apply(cmd Command) {
+	cmd.Frame = s.Frame
+
+	switch cmd.Kind {
+	case CmdSpawn:
+		s.Spawn(cmd.X, cmd.Y, cmd.Width, cmd.Height, cmd.Species)
+	case CmdSetWall:
+		s.setWallBlock(cmd, WallBlock{X: int(cmd.X), Y: int(cmd.Y), Width: cmd.Width, Height: cmd.Height})
+		for _, block := range cmd.Blocks {
+			s.setWallBlock(cmd, block)
+		}
+	case CmdClearParticles:
+		s.Particles = s.Particles[:0]
+		s.ClearSprings()
+	case CmdClearWalls:
+		clear(s.Walls)
+		s.wallsChanged()
+		clear(s.Heat)
+		clear(s.WallMaterials)
+		s.hasHeat = false
+		s.setSources(nil)
+		s.ClearBodies()
+	case CmdConfig:
+		if cmd.Config != nil {
+			s.setConfig(*cmd.Config)
+		}
+	case CmdResize:
+		s.Resize(cmd.Width, cmd.Height)
+	case CmdRescale:
+		s.Rescale(cmd.Width, cmd.Height)
+	case CmdPause:
+		s.Config.IsPaused = cmd.On
+	case CmdTimeScale:
+		s.TimeScale = ClampTimeScale(cmd.Scale)
+	case CmdStepFrame:
+		// the count may come from a recording, a corrupt one must not stall the simulation
+		for range min(max(cmd.Count, 1), MaxStepFrames) {
+			s.StepFrame()
+		}
+	case CmdStepSubstep:
+		s.StepSubstep()
+	case CmdRewind:
+		s.Rewind(cmd.Count)
+	case CmdLoadState:
+		if cmd.Config != nil {
+			s.setConfig(*cmd.Config)
+			if s.Config.Seed != 0 {
+				s.Reseed(s.Config.Seed)
+			}
+		}
+		if cmd.State != nil {
+			s.ApplyState(cmd.State)
+		}
+	case CmdForce:
+		s.Force = nil
+		if cmd.Force != nil {
+			f := *cmd.Force
+			s.Force = &f
+		}
+	case CmdAddBody:
+		if cmd.Body != nil {
+			b := *cmd.Body
+			b.X, b.Y = cmd.X, cmd.Y
+			s.AddBody(b)
+		}
+	case CmdDragBody:
+		s.DragBody(cmd.X, cmd.Y, cmd.On)
+	case CmdAddSource:
+		if cmd.Source != nil {
+			src := *cmd.Source
+			src.X, src.Y = int(cmd.X), int(cmd.Y)
+			src.Width, src.Height = cmd.Width, cmd.Height
+			s.AddSource(src)
+		}
+	}
+
+	if s.Recorder != nil {
+		if err := s.Recorder.Record(cmd); err != nil {
+			// the frames report the error until the app stops the recording
+			s.Recorder = nil
+			s.recordErr = err
+		}
+	}
+}
+
+// setWallBlock sets or erases the cells of a block with the wall settings of cmd,
+// erasing clears sources and bodies as well
+func (s *Simulation) setWallBlock(cmd Command, b WallBlock) {
+	for y := b.Y; y < b.Y+b.Height; y++ {
+		for x := b.X; x < b.X+b.Width; x++ {
+			s.SetWall(x, y, cmd.On)
+			if cmd.On {
+				s.SetHeat(x, y, cmd.Heat)
+				s.SetWallMaterial(x, y, cmd.Material)
+			}
+		}
+	}
+	if !cmd.On {
+		s.RemoveSources(b.X, b.Y, b.Width, b.Height)
+		s.RemoveBodies(b.X, b.Y, b.Width, b.Height)
+	}
+}
+
+// setConfig replaces the physics settings, a new seed restarts the random stream so
+// the run continues as if it had started with it
+func (s *Simulation) setConfig(cfg config.PhysicsConfig) {
+	if s.seedLock != 0 {
+		cfg.Seed = s.seedLock
+	}
+	reseed := cfg.Seed != 0 && cfg.Seed != s.Config.Seed
+	cfg.UpdateDerived()
+	s.Config = cfg
+	s.updateGridReach()
+	if reseed {
+		s.Reseed(cfg.Seed)
+	}
+}
+
+// StartRecording sends all further commands to rec. Recording pins the seed so the
+// random stream and the double buffered solver match on replay.
+func (s *Simulation) StartRecording(rec Recorder, seed int64) {
+	s.LockSeed(seed)
+	s.ClearRewind()
+	s.Recorder = rec
+	s.recordErr = nil
+}
+
+// StopRecording stops sending commands to the recorder. It returns the write error that
+// ended the recording early, if there was one.
+func (s *Simulation) StopRecording() error {
+	err := s.recordErr
+	s.Recorder = nil
+	s.recordErr = nil
+	s.seedLock = 0
+	return err
+}
+
+// LockSeed reseeds the simulation and keeps the seed through config changes
+func (s *Simulation) LockSeed(seed int64) {
+	s.seedLock = seed
+	s.Config.Seed = seed
+	s.Reseed(seed)
+}
+
+// StartReplay ignores live commands and applies cmds at their recorded frames
+// until the list is exhausted
+func (s *Simulation) StartReplay(cmds []Command) {
+	s.replay = cmds
+	s.Replaying = len(cmds) > 0
+	s.ClearRewind()
+}
@@ -0,0 +1,124 @@
+package simulation
+
+import "github.com/null-enjoyer/terminal-fluid-simulation/config"
+
+// edgeMargin is how far from a solid edge particles and bodies are kept, particles
+// this close to an open edge leave the domain
+const edgeMargin = 1.1
+
+// wrapPoint moves a point past a periodic edge into the domain, for looking up cells.
+// Particles may be a little past an edge between the integration and boundary passes.
+func (s *Simulation) wrapPoint(x, y float64) (float64, float64) {
+	if s.Config.WrapX {
+		x = wrapCoord(x, float64(s.Width))
+	}
+	if s.Config.WrapY {
+		y = wrapCoord(y, float64(s.Height))
+	}
+	return x, y
+}
+
+// wrapParticle moves a particle that crossed a periodic edge to the other side,
+// shifting its old position along so the velocity stays the same
+func (s *Simulation) wrapParticle(p *Particle) {
+	if s.Config.WrapX {
+		shift := wrapCoord(p.Pos.X, float64(s.Width)) - p.Pos.X
+		p.Pos.X += shift
+		p.OldPos.X += shift
+	}
+	if s.Config.WrapY {
+		shift := wrapCoord(p.Pos.Y, float64(s.Height)) - p.Pos.Y
+		p.Pos.Y += shift
+		p.OldPos.Y += shift
+	}
+}
+
+func wrapCoord(v, size float64) float64 {
+	if v < 0 {
+		v += size
+		if v < 0 || v >= size {
+			v = 0
+		}
+	} else if v >= size {
+		v -= size
+		if v >= size {
+			v = 0
+		}
+	}
+	return v
+}
+
+// nearestImage returns the shortest of the distances d and d±size along a periodic axis
+func nearestImage(d, size float64) float64 {
+	if d > size/2 {
+		return d - size
+	}
+	if d < -size/2 {
+		return d + size
+	}
+	return d
+}
+
+// seams are the sizes of the periodic axes, zero for the others
+type seams struct{ w, h float64 }
+
+func (s *Simulation) seams() seams {
+	var sm seams
+	if s.Config.WrapX {
+		sm.w = float64(s.Width)
+	}
+	if s.Config.WrapY {
+		sm.h = float64(s.Height)
+	}
+	return sm
+}
+
+func (sm seams) any() bool {
+	return sm.w > 0 || sm.h > 0
+}
+
+// nearest takes the short way across periodic edges for the offset dx, dy between two points
+func (sm seams) nearest(dx, dy float64) (float64, float64) {
+	if sm.w > 0 {
+		dx = nearestImage(dx, sm.w)
+	}
+	if sm.h > 0 {
+		dy = nearestImage(dy, sm.h)
+	}
+	return dx, dy
+}
+
+// pastOpenEdge reports whether a particle reached the margin of an open edge
+func (s *Simulation) pastOpenEdge(p *Particle) bool {
+	c := &s.Config
+	return (isOpen(c.EdgeLeft, c.WrapX) && p.Pos.X <= edgeMargin) ||
+		(isOpen(c.EdgeRight, c.WrapX) && p.Pos.X >= float64(s.Width)-edgeMargin) ||
+		(isOpen(c.EdgeTop, c.WrapY) && p.Pos.Y <= edgeMargin) ||
+		(isOpen(c.EdgeBottom, c.WrapY) && p.Pos.Y >= float64(s.Height)-edgeMargin)
+}
+
+// hasOpenEdges reports whether any edge deletes the particles leaving through it
+func (s *Simulation) hasOpenEdges() bool {
+	c := &s.Config
+	return isOpen(c.EdgeLeft, c.WrapX) || isOpen(c.EdgeRight, c.WrapX) ||
+		isOpen(c.EdgeTop, c.WrapY) || isOpen(c.EdgeBottom, c.WrapY)
+}
+
+// isOpen reports whether an edge is open, periodic opposite edges take precedence
+func isOpen(edge string, wrap bool) bool {
+	return edge == config.EdgeOpen && !wrap
+}
+
+// isSolid reports whether an edge keeps particles and bodies in the domain
+func isSolid(edge string, wrap bool) bool {
+	return edge != config.EdgeOpen && !wrap
+}
+
+// bodyPastOpenEdge reports whether a body left the domain through an open edge entirely
+func (s *Simulation) bodyPastOpenEdge(b *Body) bool {
+	c := &s.Config
+	return (isOpen(c.EdgeLeft, c.WrapX) && b.X+b.bound < 0) ||
+		(isOpen(c.EdgeRight, c.WrapX) && b.X-b.bound > float64(s.Width)) ||
+		(isOpen(c.EdgeTop, c.WrapY) && b.Y+b.bound < 0) ||
+		(isOpen(c.EdgeBottom, c.WrapY) && b.Y-b.bound > float64(s.Height))
+}
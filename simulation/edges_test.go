@@ -0,0 +1,71 @@
+package simulation
+
+import (
+	"testing"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/config"
+)
+
+// newEdges returns a simulation without gravity whose left and right edges are edge
+func newEdges(t *testing.T, edge string) *Simulation {
+	t.Helper()
+	cfg := config.NewDefaultConfig().Presets["Default"]
+	cfg.EdgeLeft, cfg.EdgeRight = edge, edge
+	cfg.ZeroGravity = true
+	cfg.UpdateDerived()
+	sim := NewSimulation(40, 20, cfg, nil)
+	t.Cleanup(sim.Close)
+	return sim
+}
+
+// moving returns a particle at x, y moving right by vx per substep
+func moving(x, y, vx float64) Particle {
+	return Particle{Pos: Vector{X: x, Y: y}, OldPos: Vector{X: x - vx, Y: y}}
+}
+
+func TestOpenEdgeRemoves(t *testing.T) {
+	sim := newEdges(t, config.EdgeOpen)
+	sim.Particles = []Particle{moving(38, 10, 0.5), moving(10, 10, 0)}
+
+	for range 5 {
+		sim.Step()
+	}
+	if len(sim.Particles) != 1 || sim.Particles[0].Pos.X > 20 {
+		t.Fatalf("particles %+v, want only the one at rest", sim.Particles)
+	}
+}
+
+func TestPeriodicEdgeWraps(t *testing.T) {
+	sim := newEdges(t, config.EdgePeriodic)
+	sim.Particles = []Particle{moving(39.5, 10, 0.5)}
+
+	sim.Step()
+	if len(sim.Particles) != 1 {
+		t.Fatalf("%d particles, want the one that crossed the edge", len(sim.Particles))
+	}
+	p := sim.Particles[0]
+	if p.Pos.X <= 0 || p.Pos.X > 5 {
+		t.Errorf("particle at x %.2f, want it just past the left edge", p.Pos.X)
+	}
+	if vx := p.Pos.X - p.OldPos.X; vx <= 0 {
+		t.Errorf("particle velocity %.2f, want it still moving right", vx)
+	}
+}
+
+// TestBodyPushesAcrossSeam puts a particle inside a body through the periodic edge, the
+// body has to find it in the grid cells on the other side and push it out
+func TestBodyPushesAcrossSeam(t *testing.T) {
+	sim := newEdges(t, config.EdgePeriodic)
+	sim.AddBody(NewCircle(1, 10, 3, 1))
+	sim.Particles = []Particle{moving(39.5, 10, 0)}
+
+	sim.UpdateMaterials()
+	sim.UpdateSpatialHash()
+	sim.pushParticles()
+	if x := sim.Particles[0].Pos.X; x >= 39.5 {
+		t.Errorf("particle at x %.2f, want it pushed left out of the body", x)
+	}
+	if x := sim.Bodies[0].X; x <= 1 {
+		t.Errorf("body at x %.2f, want it pushed right by the particle", x)
+	}
+}
@@ -0,0 +1,81 @@
+package simulation
+
+import "math"
+
+type ForceKind string
+
+const (
+	ForceAttract ForceKind = "attract"
+	ForceRepel   ForceKind = "repel"
+	ForceDrag    ForceKind = "drag"
+)
+
+// Force is a brush pushing particles within Radius of X, Y. Attract and repel accelerate
+// particles towards or away from the center like Gravity does, drag pulls their velocity
+// towards the brush velocity VX, VY in cells per frame.
+// The force falls off linearly to zero at the brush edge.
+type Force struct {
+	Kind     ForceKind `json:"kind"`
+	X        float64   `json:"x"`
+	Y        float64   `json:"y"`
+	VX       float64   `json:"vx,omitempty"`
+	VY       float64   `json:"vy,omitempty"`
+	Radius   float64   `json:"radius"`
+	Strength float64   `json:"strength"`
+}
+
+// ApplyForces runs the active force brush for one substep, before Integration so the
+// changed velocity is integrated right away
+func (s *Simulation) ApplyForces(dt float64) {
+	if s.Force == nil || s.Force.Radius <= 0 {
+		return
+	}
+	s.stepDt = dt
+	s.ParallelFor(s.passes.forces)
+}
+
+func (s *Simulation) forcesRange(start, end int) {
+	f := *s.Force
+	radSq := f.Radius * f.Radius
+	invRad := 1.0 / f.Radius
+	// attraction and repulsion accelerate, drag blends towards the cursor velocity at a rate
+	accel := f.Strength * substepScale(s.stepDt)
+	rate := f.Strength * s.stepDt
+	// velocities are displacements per substep
+	targetX, targetY := f.VX*s.stepDt, f.VY*s.stepDt
+
+	for i := start; i < end; i++ {
+		p := &s.Particles[i]
+
+		dx := p.Pos.X - f.X
+		dy := p.Pos.Y - f.Y
+		distSq := dx*dx + dy*dy
+		if distSq >= radSq {
+			continue
+		}
+		dist := math.Sqrt(distSq)
+		falloff := 1 - dist*invRad
+
+		// verlet velocity is Pos - OldPos, moving OldPos changes it
+		var dvx, dvy float64
+		switch f.Kind {
+		case ForceAttract, ForceRepel:
+			if dist < 1e-6 {
+				continue
+			}
+			a := accel * falloff / dist
+			if f.Kind == ForceAttract {
+				a = -a
+			}
+			dvx, dvy = dx*a, dy*a
+		case ForceDrag:
+			blend := math.Min(rate*falloff, 1)
+			vx := p.Pos.X - p.OldPos.X
+			vy := p.Pos.Y - p.OldPos.Y
+			dvx, dvy = (targetX-vx)*blend, (targetY-vy)*blend
+		}
+
+		p.OldPos.X -= dvx
+		p.OldPos.Y -= dvy
+	}
+}
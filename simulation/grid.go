@@ -0,0 +1,149 @@
+package simulation
+
+import "slices"
+
+// DefaultSortInterval is how many frames pass between reorders of the particles
+const DefaultSortInterval = 10
+
+// cellSpan is a run of neighboring grid columns, first to last inclusive. The particles
+// of a run within one grid row are stored next to each other in GridOrder.
+type cellSpan struct {
+	first, last int
+}
+
+// UpdateSpatialHash sorts the particle indices by grid cell with a counting sort. The
+// particles of cell c are GridOrder[CellStart[c]:CellStart[c+1]], in index order.
+func (s *Simulation) UpdateSpatialHash() {
+	n := len(s.Particles)
+	cols := s.GridCols
+	s.particleCell = grow(s.particleCell, n, false)
+	s.GridOrder = grow(s.GridOrder, n, false)
+	cells := s.particleCell
+
+	start := s.CellStart
+	clear(start)
+	for i := range s.Particles {
+		gx, gy := s.gridCell(s.Particles[i].Pos)
+		c := gx + gy*cols
+		cells[i] = c
+		start[c+1]++
+	}
+	for c := 1; c < len(start); c++ {
+		start[c] += start[c-1]
+	}
+
+	fill := s.cellFill
+	copy(fill, start)
+	for i, c := range cells {
+		s.GridOrder[fill[c]] = i
+		fill[c]++
+	}
+}
+
+// sortParticles reorders the particles by grid cell, so the neighbor searches read
+// memory close to each other. Everything indexed by particle moves along with them:
+// the solver fields kept for visualization and the spring ends.
+func (s *Simulation) sortParticles() {
+	s.UpdateSpatialHash()
+	n := len(s.Particles)
+	order := s.GridOrder[:n]
+
+	s.particleRemap = grow(s.particleRemap, n, false)
+	remap := s.particleRemap
+	sorted := true
+	for to, from := range order {
+		remap[from] = to
+		sorted = sorted && from == to
+	}
+	if sorted {
+		return
+	}
+
+	s.sortBuf = append(s.sortBuf[:0], s.Particles...)
+	for to, from := range order {
+		s.Particles[to] = s.sortBuf[from]
+	}
+	if len(s.Density) == n {
+		for _, field := range [][]float64{s.Density, s.NearDensity, s.Pressure} {
+			s.sortFloats = append(s.sortFloats[:0], field...)
+			for to, from := range order {
+				field[to] = s.sortFloats[from]
+			}
+		}
+	}
+	if len(s.Springs) > 0 {
+		s.remapSprings(remap)
+	}
+
+	// the hash now refers to the new indices
+	for k := range order {
+		order[k] = k
+	}
+}
+
+// gatherPositions copies the particle positions in grid order into posX and posY
+func (s *Simulation) gatherPositions() {
+	n := len(s.Particles)
+	s.posX = grow(s.posX, n, false)
+	s.posY = grow(s.posY, n, false)
+	for k, i := range s.GridOrder[:n] {
+		s.posX[k] = s.Particles[i].Pos.X
+		s.posY[k] = s.Particles[i].Pos.Y
+	}
+}
+
+// updateGridReach lists the grid columns and rows searched for the neighbors of a
+// particle in each column and row. Across periodic edges the search continues on the
+// other side, where the last column or row may be narrower than a full cell.
+func (s *Simulation) updateGridReach() {
+	reachX := gridReach(s.Width, s.GridCols, s.Config.WrapX)
+	s.reachX = make([][]cellSpan, len(reachX))
+	for c, cols := range reachX {
+		s.reachX[c] = columnSpans(cols)
+	}
+	s.reachY = gridReach(s.Height, s.GridRows, s.Config.WrapY)
+}
+
+func gridReach(size, cells int, wrap bool) [][]int {
+	reach := make([][]int, cells)
+	for c := range reach {
+		if !wrap || size <= 0 {
+			for n := max(c-1, 0); n <= min(c+1, cells-1); n++ {
+				reach[c] = append(reach[c], n)
+			}
+			continue
+		}
+		// every coordinate within one cell of this one, wrapped into the domain
+		cell := 1 << CellShift
+		for x := (c - 1) * cell; x < (c+2)*cell; x++ {
+			n := min((((x%size)+size)%size)>>CellShift, cells-1)
+			if !slices.Contains(reach[c], n) {
+				reach[c] = append(reach[c], n)
+			}
+		}
+	}
+	return reach
+}
+
+// columnSpans merges a list of grid columns into runs of neighboring ones
+func columnSpans(cols []int) []cellSpan {
+	cols = slices.Sorted(slices.Values(cols))
+	var spans []cellSpan
+	for _, c := range cols {
+		if len(spans) > 0 && spans[len(spans)-1].last == c-1 {
+			spans[len(spans)-1].last = c
+			continue
+		}
+		spans = append(spans, cellSpan{first: c, last: c})
+	}
+	return spans
+}
+
+// gridCell returns the grid cell of a position, wrapped across periodic edges and
+// clamped to the grid otherwise
+func (s *Simulation) gridCell(pos Vector) (int, int) {
+	x, y := s.wrapPoint(pos.X, pos.Y)
+	gx := min(max(int(x)>>CellShift, 0), s.GridCols-1)
+	gy := min(max(int(y)>>CellShift, 0), s.GridRows-1)
+	return gx, gy
+}
@@ -0,0 +1,82 @@
+package simulation
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/config"
+)
+
+// TestSortKeepsAttributes sorts shuffled particles by grid cell, every attribute and
+// spring end has to stay with the particle it belonged to, found by its position
+func TestSortKeepsAttributes(t *testing.T) {
+	const count = 400
+	sim := NewSimulation(60, 30, config.NewDefaultConfig().Presets["Default"], nil)
+	defer sim.Close()
+
+	rng := rand.New(rand.NewSource(1))
+	particles := make([]Particle, count)
+	for i, cell := range rng.Perm(56 * 26)[:count] {
+		pos := Vector{X: float64(cell%56) + 2.25, Y: float64(cell/56) + 2.5}
+		particles[i] = Particle{
+			Pos:     pos,
+			OldPos:  Vector{X: pos.X - 0.1, Y: pos.Y + float64(i)*1e-4},
+			Species: i % len(sim.Species),
+			Temp:    float64(i),
+		}
+	}
+	sim.LoadState(sim.Width, sim.Height, make([]bool, sim.Width*sim.Height), make([]int8, sim.Width*sim.Height), nil, particles)
+
+	var springs []Spring
+	for i := 0; i+7 < count; i += 5 {
+		springs = append(springs, Spring{I: i, J: i + 7, Rest: float64(i)})
+	}
+	sim.setSprings(springs)
+	sim.Density = make([]float64, count)
+	sim.NearDensity = make([]float64, count)
+	sim.Pressure = make([]float64, count)
+	for i := range count {
+		sim.Density[i], sim.NearDensity[i], sim.Pressure[i] = float64(i), float64(2*i), float64(3*i)
+	}
+
+	sim.sortParticles()
+
+	index := make(map[Vector]int, count)
+	for i, p := range sim.Particles {
+		index[p.Pos] = i
+	}
+	if len(index) != count {
+		t.Fatalf("%d distinct positions after the sort, want %d", len(index), count)
+	}
+	moved := false
+	for old, want := range particles {
+		i, ok := index[want.Pos]
+		if !ok {
+			t.Fatalf("particle at %v lost", want.Pos)
+		}
+		moved = moved || i != old
+		if got := sim.Particles[i]; got != want {
+			t.Errorf("particle at %v is %+v, want %+v", want.Pos, got, want)
+		}
+		if sim.Density[i] != float64(old) || sim.NearDensity[i] != float64(2*old) || sim.Pressure[i] != float64(3*old) {
+			t.Errorf("fields of particle at %v belong to another particle", want.Pos)
+		}
+	}
+	if !moved {
+		t.Fatal("the sort did not reorder the particles")
+	}
+
+	if len(sim.Springs) != len(springs) {
+		t.Fatalf("%d springs after the sort, want %d", len(sim.Springs), len(springs))
+	}
+	for _, sp := range sim.Springs {
+		if sp.I >= sp.J {
+			t.Errorf("spring %+v does not have I < J", sp)
+		}
+		// the rest length tells the spring, it was created between i and i+7
+		i, j := index[particles[int(sp.Rest)].Pos], index[particles[int(sp.Rest)+7].Pos]
+		if min(i, j) != sp.I || max(i, j) != sp.J {
+			t.Errorf("spring %+v joins %d and %d, want %d and %d", sp, sp.I, sp.J, min(i, j), max(i, j))
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package simulation
 
 type Vector struct {
-	X, Y float64
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
 }
 
 type Particle struct {
 	Pos    Vector
 	OldPos Vector
+	// Species indexes Simulation.Species
+	Species int
+	Temp    float64
 }
@@ -4,41 +4,6 @@ import (
 	"math"
 )
 
-const (
-	MaxVelocity = 3.0
-)
-
-func (s *Simulation) UpdateSpatialHash() {
-	for i := range s.GridHeads {
-		s.GridHeads[i] = -1
-	}
-
-	cols := s.GridCols
-	rows := s.GridRows
-
-	for i := range s.Particles {
-		p := &s.Particles[i]
-
-		gx := int(p.Pos.X) >> CellShift
-		gy := int(p.Pos.Y) >> CellShift
-
-		if gx < 0 {
-			gx = 0
-		} else if gx >= cols {
-			gx = cols - 1
-		}
-		if gy < 0 {
-			gy = 0
-		} else if gy >= rows {
-			gy = rows - 1
-		}
-
-		cellIdx := gx + gy*cols
-		s.GridNext[i] = s.GridHeads[cellIdx]
-		s.GridHeads[cellIdx] = i
-	}
-}
-
 func (s *Simulation) IsWallSafe(x, y float64) bool {
 	ix, iy := int(x), int(y)
 	if uint(ix) >= uint(s.Width) || uint(iy) >= uint(s.Height) {
@@ -47,345 +12,543 @@ func (s *Simulation) IsWallSafe(x, y float64) bool {
 	return s.Walls[ix+iy*s.Width]
 }
 
+// isBlocked reports whether x, y lies in a wall or past the domain margin the boundary
+// pass keeps particles in, periodic edges have no margin
+func (s *Simulation) isBlocked(x, y float64) bool {
+	if !s.Config.WrapX && (x < edgeMargin || x > float64(s.Width)-edgeMargin) {
+		return true
+	}
+	if !s.Config.WrapY && (y < edgeMargin || y > float64(s.Height)-edgeMargin) {
+		return true
+	}
+	return s.IsWallSafe(s.wrapPoint(x, y))
+}
+
 func (s *Simulation) Integration(dt float64) {
-	wLimit := float64(s.Width) - 1.1
-	hLimit := float64(s.Height) - 1.1
-	margin := 1.1
-	stepGravity := s.Config.Gravity * dt
-	damping := s.Config.Damping
-	width := s.Width
+	s.stepDt = dt
+	s.ParallelFor(s.passes.integrate)
+}
+
+func (s *Simulation) integrateRange(start, end int) {
+	wLimit := float64(s.Width) - edgeMargin
+	hLimit := float64(s.Height) - edgeMargin
+	margin := edgeMargin
+	accel := substepScale(s.stepDt)
+	stepGravityX := s.Config.GravityX * accel
+	stepGravityY := s.Config.GravityY * accel
+	damping := substepDamping(s.Config.Damping, s.stepDt)
+	maxMove := s.maxMove()
 	uintW, uintH := uint(s.Width), uint(s.Height)
+	hasWalls := s.hasWalls
+	mats := s.materials
+	borderFriction := s.Config.BorderFriction
+	borderRestitution := s.Config.BorderRestitution
+	wrapX, wrapY := s.Config.WrapX, s.Config.WrapY
+
+	// buoyancy pushes against gravity, straight up without gravity
+	upX, upY := 0.0, -1.0
+	if g := math.Hypot(s.Config.GravityX, s.Config.GravityY); g > 0 {
+		upX, upY = -s.Config.GravityX/g, -s.Config.GravityY/g
+	}
+	ambient := s.Config.AmbientTemp
+	stepBuoyancy := s.Config.Buoyancy * accel
 
-	s.ParallelFor(func(start, end int) {
-		for i := start; i < end; i++ {
-			p := &s.Particles[i]
+	for i := start; i < end; i++ {
+		p := &s.Particles[i]
+		m := &mats[p.Species]
+		if m.static {
+			p.OldPos = p.Pos
+			continue
+		}
 
-			vx := (p.Pos.X - p.OldPos.X) * damping
-			vy := (p.Pos.Y - p.OldPos.Y) * damping
-			vy += stepGravity
+		vx := (p.Pos.X - p.OldPos.X) * damping
+		vy := (p.Pos.Y - p.OldPos.Y) * damping
+		gravity := 1 - m.lift
+		vx += stepGravityX * gravity
+		vy += stepGravityY * gravity
+		if stepBuoyancy != 0 {
+			lift := stepBuoyancy * (p.Temp - ambient)
+			vx += upX * lift
+			vy += upY * lift
+		}
 
-			vSq := vx*vx + vy*vy
-			if vSq > MaxVelocity*MaxVelocity {
-				scale := MaxVelocity / math.Sqrt(vSq)
-				vx *= scale
-				vy *= scale
-			}
+		vSq := vx*vx + vy*vy
+		if vSq > maxMove*maxMove {
+			scale := maxMove / math.Sqrt(vSq)
+			vx *= scale
+			vy *= scale
+		}
 
-			// anti-tunneling raycast, check multiple points along the path to ensure we don't jump a wall
-			steps := int(math.Sqrt(vSq)) + 1
-			if steps > 5 {
-				steps = 5
-			}
+		// anti-tunneling raycast, check multiple points along the path to ensure we don't jump a wall
+		steps := int(math.Sqrt(vSq)) + 1
+		if steps > 5 {
+			steps = 5
+		}
 
-			startPos := p.Pos
-			collided := false
+		startPos := p.Pos
+		collided := false
 
-			for k := 1; k <= steps; k++ {
-				t := float64(k) / float64(steps)
-				testX := startPos.X + (vx * t)
-				testY := startPos.Y + (vy * t)
+		for k := 1; k <= steps; k++ {
+			t := float64(k) / float64(steps)
+			testX := startPos.X + (vx * t)
+			testY := startPos.Y + (vy * t)
 
-				ix, iy := int(testX), int(testY)
-				isWall := false
-				if uint(ix) >= uintW || uint(iy) >= uintH {
-					isWall = true
-				} else {
-					isWall = s.Walls[ix+iy*width]
-				}
+			// the screen borders below stop it, periodic edges are looked up on the other side
+			cx, cy := s.wrapPoint(testX, testY)
+			ix, iy := int(cx), int(cy)
+			if uint(ix) >= uintW || uint(iy) >= uintH {
+				break
+			}
 
-				if isWall {
-					p.OldPos = p.Pos
+			if hasWalls {
+				if dist := s.wallDistance(cx, cy); dist < 0 {
+					// the particle slides to the surface point next to the hit and bounces
+					// off it. A normal along the motion means the hit is past the middle
+					// of a thin wall, then it stays at the last free point.
+					target, n, ok := s.projectOut(Vector{X: cx, Y: cy}, dist)
+					if ok && n.X*vx+n.Y*vy < 0 {
+						p.Pos = target
+					} else {
+						speed := math.Sqrt(vSq)
+						n = Vector{X: -vx / speed, Y: -vy / speed}
+					}
+					bx, by := s.bounce(vx, vy, n, Vector{X: cx, Y: cy})
+					p.OldPos = Vector{X: p.Pos.X - bx, Y: p.Pos.Y - by}
 					collided = true
 					break
 				}
-
-				p.Pos.X = testX
-				p.Pos.Y = testY
 			}
 
-			if collided {
-				continue
-			}
+			p.Pos.X = testX
+			p.Pos.Y = testY
+		}
+
+		if collided {
+			continue
+		}
 
-			p.OldPos = startPos
+		p.OldPos = startPos
+		s.wrapParticle(p)
 
-			// screen boundaries
+		// screen boundaries, open edges stop particles like solid ones until Drain
+		// deletes them at the end of the frame
+		hitBorder := false
+		if !wrapX {
 			if p.Pos.X < margin {
 				p.Pos.X = margin
-				p.OldPos.X = margin
+				vx, vy = reflect(vx, vy, Vector{X: 1}, borderFriction, borderRestitution)
+				hitBorder = true
 			} else if p.Pos.X > wLimit {
 				p.Pos.X = wLimit
-				p.OldPos.X = wLimit
+				vx, vy = reflect(vx, vy, Vector{X: -1}, borderFriction, borderRestitution)
+				hitBorder = true
 			}
+		}
 
+		if !wrapY {
 			if p.Pos.Y < margin {
 				p.Pos.Y = margin
-				p.OldPos.Y = margin
+				vx, vy = reflect(vx, vy, Vector{Y: 1}, borderFriction, borderRestitution)
+				hitBorder = true
 			} else if p.Pos.Y > hLimit {
 				p.Pos.Y = hLimit
-				p.OldPos.Y = p.Pos.Y
+				vx, vy = reflect(vx, vy, Vector{Y: -1}, borderFriction, borderRestitution)
+				hitBorder = true
 			}
 		}
-	})
+		if hitBorder {
+			p.OldPos = Vector{X: p.Pos.X - vx, Y: p.Pos.Y - vy}
+		}
+	}
 }
 
 func (s *Simulation) SolveFluid() {
+	s.beginJacobi()
+	s.ensureFields()
+	if s.GatherPositions {
+		s.gatherPositions()
+	}
+	s.ParallelFor(s.passes.fluid)
+	for i := range s.Particles {
+		s.Particles[i].Pos = s.scratch[i]
+	}
+}
+
+// ensureFields sizes the per-particle solver outputs kept for visualization
+func (s *Simulation) ensureFields() {
+	n := len(s.Particles)
+	s.Density = grow(s.Density, n, true)
+	s.NearDensity = grow(s.NearDensity, n, true)
+	s.Pressure = grow(s.Pressure, n, true)
+}
+
+// beginJacobi prepares the scratch buffer the neighbor passes write their results to.
+// Writing results aside keeps neighbor reads independent of goroutine scheduling, and
+// keeps workers from writing positions other workers read.
+func (s *Simulation) beginJacobi() {
+	s.scratch = grow(s.scratch, len(s.Particles), false)
+}
+
+func (s *Simulation) fluidRange(start, end int) {
 	cols := s.GridCols
-	rows := s.GridRows
 	radSq := s.Config.InteractionRadSq
 	invRad := s.Config.InvInteractionRad
-	width := s.Width
 	uintW, uintH := uint(s.Width), uint(s.Height)
+	hasWalls := s.hasWalls
 	stiffness := s.Config.Stiffness
 	stiffNear := s.Config.StiffnessNear
-	restDens := s.Config.RestDensity
+	tension := s.Config.SurfaceTension
+	adhesion := s.Config.Adhesion
+	rad := s.Config.InteractionRad
+	mats := s.materials
+	wrap := s.seams()
+	wrapped := wrap.any()
+
+	cellStart, order := s.CellStart, s.GridOrder
+	gathered := s.GatherPositions
+	posX, posY := s.posX, s.posY
+
+	type Neighbor struct {
+		Index  int
+		Q      float64
+		DX, DY float64
+	}
+	var neighbors [64]Neighbor
 
-	s.ParallelFor(func(start, end int) {
-		type Neighbor struct {
-			Index int
-			Q     float64
-		}
-		var neighbors [64]Neighbor
+	for i := start; i < end; i++ {
+		p := &s.Particles[i]
+		px, py := p.Pos.X, p.Pos.Y
 
-		for i := start; i < end; i++ {
-			p := &s.Particles[i]
+		gx, gy := s.gridCell(p.Pos)
 
-			gx := int(p.Pos.X) >> CellShift
-			gy := int(p.Pos.Y) >> CellShift
+		density := 0.0
+		nearDensity := 0.0
+		neighborCount := 0
 
-			density := 0.0
-			nearDensity := 0.0
-			neighborCount := 0
+		for _, y := range s.reachY[gy] {
+			row := y * cols
+			for _, span := range s.reachX[gx] {
+				for k := cellStart[row+span.first]; k < cellStart[row+span.last+1]; k++ {
+					nj := order[k]
+					if nj == i {
+						continue
+					}
+					var dx, dy float64
+					if gathered {
+						dx, dy = posX[k]-px, posY[k]-py
+					} else {
+						pj := &s.Particles[nj]
+						dx, dy = pj.Pos.X-px, pj.Pos.Y-py
+					}
+					if wrapped {
+						dx, dy = wrap.nearest(dx, dy)
+					}
+					rSq := dx*dx + dy*dy
 
-			startX := gx - 1
-			if startX < 0 {
-				startX = 0
-			}
-			endX := gx + 1
-			if endX >= cols {
-				endX = cols - 1
-			}
-			startY := gy - 1
-			if startY < 0 {
-				startY = 0
-			}
-			endY := gy + 1
-			if endY >= rows {
-				endY = rows - 1
-			}
+					if rSq < radSq && rSq > 1e-6 {
+						r := math.Sqrt(rSq)
+						q := 1.0 - (r * invRad)
+						q2 := q * q
+
+						density += q2
+						nearDensity += q2 * q
 
-			for x := startX; x <= endX; x++ {
-				yOffset := x
-				for y := startY; y <= endY; y++ {
-					cellIdx := yOffset + y*cols
-					nj := s.GridHeads[cellIdx]
-
-					for nj != -1 {
-						if nj != i {
-							pj := &s.Particles[nj]
-							dx := pj.Pos.X - p.Pos.X
-							dy := pj.Pos.Y - p.Pos.Y
-							rSq := dx*dx + dy*dy
-
-							if rSq < radSq && rSq > 1e-6 {
-								r := math.Sqrt(rSq)
-								q := 1.0 - (r * invRad)
-								q2 := q * q
-
-								density += q2
-								nearDensity += q2 * q
-
-								if neighborCount < 64 {
-									neighbors[neighborCount] = Neighbor{nj, q}
-									neighborCount++
-								}
-							}
+						if neighborCount < 64 {
+							neighbors[neighborCount] = Neighbor{nj, q, dx, dy}
+							neighborCount++
 						}
-						nj = s.GridNext[nj]
 					}
 				}
 			}
+		}
+
+		mi := mats[p.Species]
+		pressure := stiffness * (density - mi.restDensity)
+		nearPressure := stiffNear * nearDensity
 
-			pressure := stiffness * (density - restDens)
-			nearPressure := stiffNear * nearDensity
+		s.Density[i] = density
+		s.NearDensity[i] = nearDensity
+		s.Pressure[i] = pressure
 
-			pVecX, pVecY := 0.0, 0.0
+		if mi.static {
+			s.scratch[i] = p.Pos
+			continue
+		}
 
-			for k := 0; k < neighborCount; k++ {
-				n := neighbors[k]
-				pj := &s.Particles[n.Index]
+		pVecX, pVecY := 0.0, 0.0
 
-				dm := (pressure * n.Q) + (nearPressure * n.Q * n.Q)
+		for k := 0; k < neighborCount; k++ {
+			n := neighbors[k]
+			pj := &s.Particles[n.Index]
 
-				dx := pj.Pos.X - p.Pos.X
-				dy := pj.Pos.Y - p.Pos.Y
-				dist := math.Sqrt(dx*dx + dy*dy)
+			// cohesion pulls neighbors together, strongest at half the interaction radius
+			dm := (pressure * n.Q) + (nearPressure * n.Q * n.Q) - tension*n.Q*(1-n.Q)
 
-				if dist > 1e-4 {
-					invDist := 1.0 / dist
-					moveX := (dx * invDist) * dm * 0.5
-					moveY := (dy * invDist) * dm * 0.5
+			dx, dy := n.DX, n.DY
+			dist := math.Sqrt(dx*dx + dy*dy)
 
-					pVecX -= moveX
-					pVecY -= moveY
-				}
-			}
+			if dist > 1e-4 {
+				// lighter particle of the pair takes the larger share of the correction
+				share := pairShare(mi.invMass, mats[pj.Species].invMass)
+				invDist := 1.0 / dist
+				moveX := (dx * invDist) * dm * share
+				moveY := (dy * invDist) * dm * share
 
-			// high pressure can cause massive jumps, so clamp to 1.0 pixel max per step
-			pVecLenSq := pVecX*pVecX + pVecY*pVecY
-			if pVecLenSq > 1.0 {
-				scale := 1.0 / math.Sqrt(pVecLenSq)
-				pVecX *= scale
-				pVecY *= scale
+				pVecX -= moveX
+				pVecY -= moveY
 			}
+		}
 
-			targetPX := p.Pos.X + pVecX
-			targetPY := p.Pos.Y + pVecY
+		if adhesion > 0 {
+			ax, ay := s.wallAdhesion(p.Pos, rad, invRad)
+			pVecX += ax * adhesion * mi.invMass
+			pVecY += ay * adhesion * mi.invMass
+		}
 
-			// check wall collision
-			ix, iy := int(targetPX), int(targetPY)
-			isWall := false
-			if uint(ix) >= uintW || uint(iy) >= uintH {
-				isWall = true
-			} else {
-				isWall = s.Walls[ix+iy*width]
-			}
+		// high pressure can cause massive jumps, so clamp to 1.0 pixel max per step
+		pVecLenSq := pVecX*pVecX + pVecY*pVecY
+		if pVecLenSq > 1.0 {
+			scale := 1.0 / math.Sqrt(pVecLenSq)
+			pVecX *= scale
+			pVecY *= scale
+		}
+
+		targetPX := p.Pos.X + pVecX
+		targetPY := p.Pos.Y + pVecY
+
+		// check wall collision, the boundary pass wraps targets past periodic edges
+		cx, cy := s.wrapPoint(targetPX, targetPY)
+		ix, iy := int(cx), int(cy)
+		isWall := false
+		if uint(ix) >= uintW || uint(iy) >= uintH {
+			isWall = true
+		} else if hasWalls {
+			isWall = s.wallDistance(cx, cy) < 0
+		}
+
+		target := p.Pos
+		if !isWall {
+			target = Vector{X: targetPX, Y: targetPY}
+		}
+		s.scratch[i] = target
+	}
+}
 
-			if !isWall {
-				p.Pos.X = targetPX
-				p.Pos.Y = targetPY
+// wallAdhesion sums the pull of the wall cells within rad of pos, using the same
+// kernel as cohesion so fluid settles along walls instead of sinking into them.
+// Slippery walls do not pull.
+func (s *Simulation) wallAdhesion(pos Vector, rad, invRad float64) (float64, float64) {
+	r := int(math.Ceil(rad))
+	cx, cy := int(pos.X), int(pos.Y)
+	fx, fy := 0.0, 0.0
+
+	for y := cy - r; y <= cy+r; y++ {
+		if uint(y) >= uint(s.Height) {
+			continue
+		}
+		for x := cx - r; x <= cx+r; x++ {
+			idx := x + y*s.Width
+			if uint(x) >= uint(s.Width) || !s.Walls[idx] || s.WallMaterials[idx] == WallSlippery {
+				continue
+			}
+			dx := float64(x) + 0.5 - pos.X
+			dy := float64(y) + 0.5 - pos.Y
+			dist := math.Sqrt(dx*dx + dy*dy)
+			if dist >= rad || dist < 1e-4 {
+				continue
 			}
+			q := 1.0 - dist*invRad
+			w := q * (1 - q) / dist
+			fx += dx * w
+			fy += dy * w
 		}
-	})
+	}
+	return fx, fy
 }
 
+// SolveViscosity applies the viscosity impulses and diffuses temperature between
+// neighbors. New temperatures are always buffered, they are not part of the verlet state.
 func (s *Simulation) SolveViscosity() {
+	s.beginJacobi()
+
+	n := len(s.Particles)
+	s.temps = grow(s.temps, n, false)
+	s.viscs = grow(s.viscs, n, false)
+
+	coeff := s.Config.ThermalViscosity
+	for i := range s.Particles {
+		p := &s.Particles[i]
+		s.viscs[i] = s.materials[p.Species].viscosity
+		if coeff != 0 {
+			s.viscs[i] *= viscosityScale(p.Temp, s.Config.AmbientTemp, coeff)
+		}
+	}
+
+	if s.GatherPositions {
+		s.gatherPositions()
+	}
+	s.ParallelFor(s.passes.viscosity)
+	for i := range s.Particles {
+		p := &s.Particles[i]
+		p.Temp = s.temps[i]
+		p.OldPos = s.scratch[i]
+	}
+}
+
+func (s *Simulation) viscosityRange(start, end int) {
 	cols := s.GridCols
-	rows := s.GridRows
 	radSq := s.Config.InteractionRadSq
 	invRad := s.Config.InvInteractionRad
-	visc := s.Config.Viscosity
+	viscs := s.viscs
+	mats := s.materials
+	diffusion := math.Min(s.Config.Conductivity*s.stepDt, 1)
+	wrap := s.seams()
+	wrapped := wrap.any()
+	cellStart, order := s.CellStart, s.GridOrder
+	gathered := s.GatherPositions
+	posX, posY := s.posX, s.posY
+
+	for i := start; i < end; i++ {
+		p := &s.Particles[i]
+		px, py := p.Pos.X, p.Pos.Y
+		visc := viscs[i]
+		static := mats[p.Species].static
+		old := p.OldPos
+		tempSum, weightSum := 0.0, 0.0
+
+		gx, gy := s.gridCell(p.Pos)
+
+		for _, y := range s.reachY[gy] {
+			row := y * cols
+			for _, span := range s.reachX[gx] {
+				for k := cellStart[row+span.first]; k < cellStart[row+span.last+1]; k++ {
+					nj := order[k]
+					if nj == i {
+						continue
+					}
+					var dx, dy float64
+					if gathered {
+						dx, dy = posX[k]-px, posY[k]-py
+					} else {
+						pj := &s.Particles[nj]
+						dx, dy = pj.Pos.X-px, pj.Pos.Y-py
+					}
+					if wrapped {
+						dx, dy = wrap.nearest(dx, dy)
+					}
+					rSq := dx*dx + dy*dy
 
-	s.ParallelFor(func(start, end int) {
-		for i := start; i < end; i++ {
-			p := &s.Particles[i]
+					if rSq < radSq && rSq > 1e-6 {
+						pj := &s.Particles[nj]
+						r := math.Sqrt(rSq)
+						q := 1 - (r * invRad)
 
-			gx := int(p.Pos.X) >> CellShift
-			gy := int(p.Pos.Y) >> CellShift
+						tempSum += pj.Temp * q
+						weightSum += q
 
-			startX := gx - 1
-			if startX < 0 {
-				startX = 0
-			}
-			endX := gx + 1
-			if endX >= cols {
-				endX = cols - 1
-			}
-			startY := gy - 1
-			if startY < 0 {
-				startY = 0
-			}
-			endY := gy + 1
-			if endY >= rows {
-				endY = rows - 1
-			}
+						invR := 1.0 / r
+						nx, ny := dx*invR, dy*invR
 
-			for x := startX; x <= endX; x++ {
-				yOffset := x
-				for y := startY; y <= endY; y++ {
-					cellIdx := yOffset + y*cols
-					nj := s.GridHeads[cellIdx]
-
-					for nj != -1 {
-						if nj != i {
-							pj := &s.Particles[nj]
-							dx := pj.Pos.X - p.Pos.X
-							dy := pj.Pos.Y - p.Pos.Y
-							rSq := dx*dx + dy*dy
-
-							if rSq < radSq && rSq > 1e-6 {
-								r := math.Sqrt(rSq)
-
-								invR := 1.0 / r
-								nx, ny := dx*invR, dy*invR
-
-								v1x := p.Pos.X - p.OldPos.X
-								v1y := p.Pos.Y - p.OldPos.Y
-								v2x := pj.Pos.X - pj.OldPos.X
-								v2y := pj.Pos.Y - pj.OldPos.Y
-
-								velAlongNormal := (v1x-v2x)*nx + (v1y-v2y)*ny
-
-								if velAlongNormal > 0 {
-									impulse := velAlongNormal * (1 - (r * invRad)) * visc
-									ix, iy := nx*impulse, ny*impulse
-									p.OldPos.X -= ix
-									p.OldPos.Y -= iy
-								}
-							}
+						v1x := px - old.X
+						v1y := py - old.Y
+						v2x := pj.Pos.X - pj.OldPos.X
+						v2y := pj.Pos.Y - pj.OldPos.Y
+
+						velAlongNormal := (v1x-v2x)*nx + (v1y-v2y)*ny
+
+						if velAlongNormal > 0 && !static {
+							pairVisc := (visc + viscs[nj]) * 0.5
+							impulse := velAlongNormal * q * pairVisc
+							ix, iy := nx*impulse, ny*impulse
+							old.X -= ix
+							old.Y -= iy
 						}
-						nj = s.GridNext[nj]
 					}
 				}
 			}
 		}
-	})
+
+		temp := p.Temp
+		if weightSum > 0 && diffusion > 0 {
+			temp += (tempSum/weightSum - temp) * diffusion
+		}
+		s.temps[i] = temp
+
+		s.scratch[i] = old
+	}
 }
 
 func (s *Simulation) EnforceBoundaries() {
+	s.ParallelFor(s.passes.boundaries)
+}
+
+// cardinalDirs are the unit steps a particle stuck in a wall tries to get out by
+var cardinalDirs = [...]struct{ dx, dy float64 }{
+	{0, -1}, {0, 1}, {-1, 0}, {1, 0},
+}
+
+func (s *Simulation) boundariesRange(start, end int) {
 	uintW, uintH := uint(s.Width), uint(s.Height)
 	width := s.Width
 
-	s.ParallelFor(func(start, end int) {
-		for i := start; i < end; i++ {
-			p := &s.Particles[i]
+	for i := start; i < end; i++ {
+		p := &s.Particles[i]
+		s.wrapParticle(p)
+
+		ix, iy := int(p.Pos.X), int(p.Pos.Y)
+		isWall := false
+		dist := 0.0
+		if uint(ix) >= uintW || uint(iy) >= uintH {
+			isWall = true
+		} else if s.hasWalls {
+			dist = s.wallDistance(p.Pos.X, p.Pos.Y)
+			isWall = dist < 0
+		}
+		if !isWall {
+			continue
+		}
+
+		// project the particle out along the surface normal, however deep it is
+		if dist < 0 {
+			if target, n, ok := s.projectOut(p.Pos, dist); ok {
+				bx, by := s.bounce(p.Pos.X-p.OldPos.X, p.Pos.Y-p.OldPos.Y, n, p.Pos)
+				p.Pos = target
+				p.OldPos = Vector{X: target.X - bx, Y: target.Y - by}
+				continue
+			}
+		}
 
-			ix, iy := int(p.Pos.X), int(p.Pos.Y)
-			isWall := false
-			if uint(ix) >= uintW || uint(iy) >= uintH {
-				isWall = true
-			} else {
-				isWall = s.Walls[ix+iy*width]
+		// no usable normal in the middle of thin walls and outside the domain
+		oix, oiy := int(p.OldPos.X), int(p.OldPos.Y)
+		oldSafe := false
+		if uint(oix) < uintW && uint(oiy) < uintH {
+			if !s.Walls[oix+oiy*width] {
+				oldSafe = true
 			}
+		}
 
-			if isWall {
-				oix, oiy := int(p.OldPos.X), int(p.OldPos.Y)
-				oldSafe := false
-				if uint(oix) < uintW && uint(oiy) < uintH {
-					if !s.Walls[oix+oiy*width] {
-						oldSafe = true
+		if oldSafe {
+			p.Pos = p.OldPos
+		} else {
+			foundSafe := false
+
+			for _, d := range cardinalDirs {
+				nx, ny := p.Pos.X+d.dx, p.Pos.Y+d.dy
+				nix, niy := int(nx), int(ny)
+				if uint(nix) < uintW && uint(niy) < uintH {
+					if !s.Walls[nix+niy*width] {
+						p.Pos.X = nx
+						p.Pos.Y = ny
+						foundSafe = true
+						break
 					}
 				}
+			}
 
-				if oldSafe {
-					p.Pos = p.OldPos
-				} else {
-					foundSafe := false
-
-					// check 4 cardinal directions
-					dirs := []struct{ dx, dy float64 }{
-						{0, -1}, {0, 1}, {-1, 0}, {1, 0},
-					}
-
-					for _, d := range dirs {
-						nx, ny := p.Pos.X+d.dx, p.Pos.Y+d.dy
-						nix, niy := int(nx), int(ny)
-						if uint(nix) < uintW && uint(niy) < uintH {
-							if !s.Walls[nix+niy*width] {
-								p.Pos.X = nx
-								p.Pos.Y = ny
-								foundSafe = true
-								break
-							}
-						}
-					}
-
-					if !foundSafe {
-						p.Pos = p.OldPos
-					}
-				}
-				p.OldPos = p.Pos
+			if !foundSafe {
+				p.Pos = p.OldPos
 			}
 		}
-	})
+		p.OldPos = p.Pos
+	}
 }
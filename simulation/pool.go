@@ -0,0 +1,106 @@
+package simulation
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// minChunk keeps chunks large enough that claiming one costs less than processing it
+const minChunk = 256
+
+// WorkerPool runs ranged jobs on long-lived goroutines. A job is split into chunks that
+// workers claim from a shared counter, so workers that finish early take over the rest.
+type WorkerPool struct {
+	Workers int
+
+	action func(start, end int)
+	count  int
+	chunk  int
+	next   atomic.Int64
+
+	wake    chan struct{}
+	quit    chan struct{}
+	pending sync.WaitGroup
+	exited  sync.WaitGroup
+}
+
+// NewWorkerPool starts a pool, workers < 1 uses one worker per CPU
+func NewWorkerPool(workers int) *WorkerPool {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	p := &WorkerPool{
+		Workers: workers,
+		wake:    make(chan struct{}, workers),
+		quit:    make(chan struct{}),
+	}
+
+	// the calling goroutine works too, so one fewer background worker is needed
+	for i := 1; i < workers; i++ {
+		p.exited.Add(1)
+		go p.worker()
+	}
+	return p
+}
+
+// Run processes [0, count) in chunks and blocks until every chunk is done
+func (p *WorkerPool) Run(count int, action func(start, end int)) {
+	chunk := count / (p.Workers * 8)
+	if chunk < minChunk {
+		chunk = minChunk
+	}
+
+	p.action = action
+	p.count = count
+	p.chunk = chunk
+	p.next.Store(0)
+
+	helpers := (count+chunk-1)/chunk - 1
+	if helpers > p.Workers-1 {
+		helpers = p.Workers - 1
+	}
+
+	p.pending.Add(helpers)
+	for i := 0; i < helpers; i++ {
+		p.wake <- struct{}{}
+	}
+	p.drain()
+	p.pending.Wait()
+
+	p.action = nil
+}
+
+// Stop shuts down the workers, the pool must not be used afterwards
+func (p *WorkerPool) Stop() {
+	close(p.quit)
+	p.exited.Wait()
+}
+
+func (p *WorkerPool) worker() {
+	defer p.exited.Done()
+	for {
+		select {
+		case <-p.wake:
+			p.drain()
+			p.pending.Done()
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *WorkerPool) drain() {
+	for {
+		end := int(p.next.Add(int64(p.chunk)))
+		start := end - p.chunk
+		if start >= p.count {
+			return
+		}
+		if end > p.count {
+			end = p.count
+		}
+		p.action(start, end)
+	}
+}
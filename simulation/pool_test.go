@@ -0,0 +1,45 @@
+package simulation
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// BenchmarkParallelFor compares the worker pool with starting a goroutine per chunk on
+// every call, over a pass the size of a full particle cap
+func BenchmarkParallelFor(b *testing.B) {
+	data := make([]float64, DefaultMaxParticles)
+	action := func(start, end int) {
+		for i := start; i < end; i++ {
+			data[i] = math.Sqrt(data[i] + float64(i))
+		}
+	}
+	workers := runtime.NumCPU()
+
+	b.Run("pool", func(b *testing.B) {
+		pool := NewWorkerPool(workers)
+		defer pool.Stop()
+		b.ReportAllocs()
+		for b.Loop() {
+			pool.Run(len(data), action)
+		}
+	})
+
+	b.Run("spawn", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			chunk := (len(data) + workers - 1) / workers
+			var wg sync.WaitGroup
+			for start := 0; start < len(data); start += chunk {
+				wg.Add(1)
+				go func(start, end int) {
+					defer wg.Done()
+					action(start, end)
+				}(start, min(start+chunk, len(data)))
+			}
+			wg.Wait()
+		}
+	})
+}
@@ -0,0 +1,193 @@
+package simulation
+
+import "unsafe"
+
+const (
+	// DefaultRewindFrames is the rewind buffer depth suggested for interactive use, the
+	// buffer is off unless RewindFrames is set
+	DefaultRewindFrames = 300
+	// rewindBudget bounds the bytes of particles, springs and bodies stored over all
+	// frames of the rewind buffer. Large scenes keep fewer frames.
+	rewindBudget = 120 << 20
+)
+
+// rewindFrame is the particle state at the end of a simulated frame, or partway through
+// one when it was stepped substep by substep
+type rewindFrame struct {
+	simulated     uint64
+	substep       int
+	subSteps      int
+	subDt         float64
+	width, height int
+	particles     []Particle
+	springs       []Spring
+	bodies        []Body
+	bytes         int
+}
+
+// rewindRing holds the newest frames of the rewind buffer. The slots are allocated once
+// and keep their buffers when a frame is dropped, so a full buffer stores the next frame
+// without allocating.
+type rewindRing struct {
+	slots []rewindFrame
+	start int // slot of the oldest frame
+	count int
+	bytes int
+}
+
+// at returns the frame k places after the oldest one
+func (r *rewindRing) at(k int) *rewindFrame {
+	return &r.slots[(r.start+k)%len(r.slots)]
+}
+
+// dropOldest forgets the oldest frame, its slot is reused by a later one
+func (r *rewindRing) dropOldest() {
+	r.bytes -= r.at(0).bytes
+	r.start = (r.start + 1) % len(r.slots)
+	r.count--
+}
+
+// dropNewest forgets the n newest frames
+func (r *rewindRing) dropNewest(n int) {
+	for ; n > 0 && r.count > 0; n-- {
+		r.count--
+		r.bytes -= r.at(r.count).bytes
+	}
+}
+
+// StepFrame advances by one frame and pauses. A rewound state moves forward through
+// the rewind buffer, otherwise the rest of the current frame is simulated.
+func (s *Simulation) StepFrame() {
+	s.Config.IsPaused = true
+	if s.rewindPos > 0 {
+		s.Rewind(-1)
+		return
+	}
+	for !s.advanceSubstep() {
+	}
+}
+
+// StepSubstep simulates the next substep and pauses, the frame ends after its last one
+func (s *Simulation) StepSubstep() {
+	s.Config.IsPaused = true
+	s.advanceSubstep()
+}
+
+// Rewind moves the particle state frames back through the rewind buffer, or forward for
+// a negative count, as far as the buffer goes, and pauses. Walls and sources stay as
+// they are. Simulating from a rewound state drops the frames after it.
+func (s *Simulation) Rewind(frames int) {
+	s.Config.IsPaused = true
+	if s.RewindFrames <= 0 {
+		return
+	}
+	if s.rewindPos == 0 && frames > 0 {
+		// keep the changes since the newest frame, scrubbing forward returns to them
+		s.saveRewind()
+	}
+	r := &s.rewind
+	if r.count == 0 {
+		return
+	}
+
+	pos := min(max(s.rewindPos+frames, 0), r.count-1)
+	if pos == s.rewindPos {
+		return
+	}
+	s.rewindPos = pos
+	s.restoreRewind(r.at(r.count - 1 - pos))
+}
+
+// ClearRewind empties the rewind buffer, a recording or replay cannot rewind to frames
+// from before it started
+func (s *Simulation) ClearRewind() {
+	s.rewind.dropNewest(s.rewind.count)
+	s.rewindPos = 0
+}
+
+// saveRewind stores the current state as the newest frame of the rewind buffer,
+// replacing an entry of the same frame and substep. The oldest frames make room once
+// the buffer holds RewindFrames frames or rewindBudget bytes.
+func (s *Simulation) saveRewind() {
+	r := &s.rewind
+	if s.RewindFrames <= 0 {
+		*r = rewindRing{}
+		return
+	}
+	if len(r.slots) != s.RewindFrames {
+		*r = rewindRing{slots: make([]rewindFrame, s.RewindFrames)}
+	}
+
+	if r.count > 0 {
+		last := r.at(r.count - 1)
+		if last.simulated == s.Simulated && last.substep == s.substep {
+			r.dropNewest(1)
+		}
+	}
+	size := s.stateBytes()
+	for r.count > 0 && (r.count == len(r.slots) || r.bytes+size > rewindBudget) {
+		r.dropOldest()
+	}
+
+	entry := r.at(r.count)
+	r.count++
+	r.bytes += size
+	entry.bytes = size
+	entry.simulated = s.Simulated
+	entry.substep = s.substep
+	entry.subSteps, entry.subDt = s.subSteps, s.subDt
+	entry.width, entry.height = s.Width, s.Height
+	entry.particles = append(entry.particles[:0], s.Particles...)
+	entry.springs = append(entry.springs[:0], s.Springs...)
+	entry.bodies = copyBodies(entry.bodies, s.Bodies)
+}
+
+// stateBytes is the memory a frame of the rewind buffer takes for the current state
+func (s *Simulation) stateBytes() int {
+	size := len(s.Particles)*int(unsafe.Sizeof(Particle{})) + len(s.Springs)*int(unsafe.Sizeof(Spring{}))
+	for i := range s.Bodies {
+		b := &s.Bodies[i]
+		size += int(unsafe.Sizeof(Body{})) + (len(b.world)+len(b.normals)+len(b.samples))*int(unsafe.Sizeof(Vector{}))
+	}
+	return size
+}
+
+// copyBodies copies bodies into dst, reusing the vertex buffers of the bodies in dst.
+// Spare capacity is not reused, removing bodies can leave copies there that share the
+// buffers of the bodies before them.
+func copyBodies(dst, bodies []Body) []Body {
+	old := dst
+	dst = dst[:0]
+	for i, b := range bodies {
+		if i < len(old) {
+			b.world = append(old[i].world[:0], b.world...)
+			b.normals = append(old[i].normals[:0], b.normals...)
+			b.samples = append(old[i].samples[:0], b.samples...)
+		} else {
+			b = b.clone()
+		}
+		dst = append(dst, b)
+	}
+	return dst
+}
+
+func (s *Simulation) restoreRewind(e *rewindFrame) {
+	s.Particles = append(s.Particles[:0], e.particles...)
+	s.setSprings(e.springs)
+	s.Bodies = copyBodies(s.Bodies, e.bodies)
+	s.grab = nil
+	if e.width != s.Width || e.height != s.Height {
+		s.clampParticles()
+		s.clampBodies()
+	}
+
+	s.Simulated = e.simulated
+	s.substep = e.substep
+	s.subSteps, s.subDt = e.subSteps, e.subDt
+}
+
+// dropFuture forgets the frames after a rewound state before it is simulated anew
+func (s *Simulation) dropFuture() {
+	s.rewind.dropNewest(s.rewindPos)
+	s.rewindPos = 0
+}
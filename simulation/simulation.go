@@ -1,8 +1,8 @@
 package simulation
 
 import (
+	"math"
 	"math/rand"
-	"runtime"
 	"sync"
 	"time"
 
@@ -11,46 +11,218 @@ import (
 )
 
 const (
-	MaxParticles = 45000
-	CellShift    = 2
-	SidebarWidth = 32
-	SubSteps     = 4
+	DefaultMaxParticles = 45000
+	CellShift           = 2
+	SidebarWidth        = 32
+	// SubSteps per base frame, the least a step is split into, see planSubsteps
+	SubSteps = 4
+	// maxSpawn bounds the particles of one spawn, a large brush spreads them thinner
+	maxSpawn = 500
+	// frameBuffers is the number of frames cycling between Run and the renderer: one
+	// being filled, one waiting in RenderChan and one on screen
+	frameBuffers = 3
 )
 
 type Simulation struct {
 	Particles []Particle
-	GridHeads []int
-	GridNext  []int
-	Walls     []bool
+	// MaxParticles caps the particle count, spawning stops once it is reached. Zero or
+	// less is unlimited, the buffers indexed by particle grow on demand either way.
+	MaxParticles int
+	// CellStart and GridOrder are the particles sorted by grid cell, see UpdateSpatialHash
+	CellStart     []int
+	GridOrder     []int
+	Walls         []bool
+	Heat          []int8 // hot and cold marks of wall cells
+	WallMaterials []WallMaterial
+	Sources       []Source
+	Force         *Force // active force brush, nil when released
+	Springs       []Spring
+	Bodies        []Body
+	grab          *bodyGrab // body held by the cursor
+	contacts      []contact
+	springSet     map[uint64]struct{}
+	springDegree  []int
 
 	GridCols int
 	GridRows int
-	Width    int
-	Height   int
+	// grid columns and rows searched for neighbors, see updateGridReach
+	reachX [][]cellSpan
+	reachY [][]int
+
+	// SortInterval is the number of frames between reorders of the particles by grid
+	// cell, zero keeps them in spawn order. GatherPositions copies the positions into
+	// coordinate arrays in grid order before each neighbor search, so the search reads
+	// them contiguously. The particles stay the only storage and the results are the same.
+	SortInterval    int
+	GatherPositions bool
+	particleCell    []int
+	cellFill        []int
+	particleRemap   []int // new particle indices after a sort or removal, see remapSprings
+	sortBuf         []Particle
+	sortFloats      []float64
+	posX, posY      []float64
+	Width           int
+	Height          int
+
+	CmdChan chan Command
+	// RenderChan delivers the latest frame, hand it back with ReleaseFrame once drawn
+	RenderChan chan *render.FrameSnapshot
+	freeFrames chan *render.FrameSnapshot
+	// ControlChan runs requests that are not user actions and are never recorded, like captures
+	ControlChan chan func(*Simulation)
+	Config      config.PhysicsConfig
+	Species     []config.Species
+
+	// signed distance field of the walls, rebuilt at the next step once they changed
+	wallDist   []float32
+	wallNX     []float32
+	wallNY     []float32
+	hasWalls   bool
+	wallsDirty bool
+	// wallVersion counts changes of the wall cells, heat and materials included. The
+	// copy sent to the renderer is only rebuilt when it moved on.
+	wallVersion uint64
+	wallCopy    *render.Walls
+	edt         struct {
+		grid, f, d, z []float64
+		v             []int
+	}
+
+	materials []material
+	drainMask []bool
+	hasDrains bool
+	hasHeat   bool
+	temps     []float64 // temperatures after diffusion
+	viscs     []float64 // per-particle viscosity for the current substep
 
-	CmdChan    chan func(*Simulation)
-	RenderChan chan render.FrameSnapshot
-	Config     config.PhysicsConfig
+	// per-particle values from the last SolveFluid, indexed like Particles
+	Density     []float64
+	NearDensity []float64
+	Pressure    []float64
 
-	NumCPU int
+	Pool *WorkerPool
+
+	// parallel passes bound once so dispatching them does not allocate
+	passes struct {
+		integrate, forces, fluid, viscosity, boundaries func(start, end int)
+	}
+	stepDt float64
+
+	// StepRate is the number of steps Run takes per second, zero uses DefaultStepRate.
+	// TimeScale speeds up or slows down the simulated time against the real one.
+	StepRate  float64
+	TimeScale float64
+	// substeps of the last step and their length in base frames
+	subSteps int
+	subDt    float64
+
+	// Rand drives all randomness so seeded runs repeat exactly
+	Rand     *rand.Rand
+	seedLock int64
+	scratch  []Vector // results of the double buffered passes
+
+	// Frame counts Step calls, commands are stamped with it. Simulated counts the frames
+	// of physics run, it stands still while paused and moves with the rewind buffer.
+	Frame     uint64
+	Simulated uint64
+	// substep is the next substep of the current frame, zero between frames
+	substep int
+	// RewindFrames is the depth of the rewind buffer, zero disables it
+	RewindFrames int
+	rewind       rewindRing
+	rewindPos    int // frames the state is behind the newest one of the buffer
+
+	Recorder  Recorder
+	recordErr error // the write error that dropped Recorder, see StopRecording
+	Replaying bool
+	replay    []Command
+
+	// lifecycle guards started and closed, Close may race the start of Run
+	lifecycle sync.Mutex
+	started   bool
+	closed    bool
+	quit      chan struct{}
+	done      chan struct{}
 }
 
-func NewSimulation(termW, termH int, cfg config.PhysicsConfig) *Simulation {
+// NewSimulation creates a simulation domain of width x height cells
+func NewSimulation(width, height int, cfg config.PhysicsConfig, species []config.Species) *Simulation {
 	cfg.UpdateDerived()
 
+	if len(species) == 0 {
+		species = config.DefaultSpecies()
+	}
+
 	sim := &Simulation{
-		Particles:  make([]Particle, 0, MaxParticles),
-		GridNext:   make([]int, MaxParticles),
-		CmdChan:    make(chan func(*Simulation), 100),
-		RenderChan: make(chan render.FrameSnapshot, 2),
-		Config:     cfg,
-		NumCPU:     runtime.NumCPU(),
+		MaxParticles: DefaultMaxParticles,
+		CmdChan:      make(chan Command, 100),
+		ControlChan:  make(chan func(*Simulation), 10),
+		RenderChan:   make(chan *render.FrameSnapshot, 1),
+		freeFrames:   make(chan *render.FrameSnapshot, frameBuffers),
+		Config:       cfg,
+		Species:      species,
+		Pool:         NewWorkerPool(0),
+		SortInterval: DefaultSortInterval,
+		TimeScale:    1,
+		subSteps:     SubSteps,
+		subDt:        1.0 / SubSteps,
+		Rand:         newRand(cfg.Seed),
+		quit:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+
+	for range frameBuffers {
+		sim.freeFrames <- &render.FrameSnapshot{}
 	}
 
-	sim.Resize(termW, termH)
+	sim.passes.integrate = sim.integrateRange
+	sim.passes.forces = sim.forcesRange
+	sim.passes.fluid = sim.fluidRange
+	sim.passes.viscosity = sim.viscosityRange
+	sim.passes.boundaries = sim.boundariesRange
+
+	sim.Resize(width, height)
 	return sim
 }
 
+// Reseed restarts the random stream, seed 0 picks a random seed
+func (s *Simulation) Reseed(seed int64) {
+	s.Rand = newRand(seed)
+}
+
+func newRand(seed int64) *rand.Rand {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// SetWorkers replaces the worker pool, n < 1 uses one worker per CPU.
+// Must not be called while a step is running.
+func (s *Simulation) SetWorkers(n int) {
+	s.Pool.Stop()
+	s.Pool = NewWorkerPool(n)
+}
+
+// full reports whether the particle count reached MaxParticles
+func (s *Simulation) full() bool {
+	return s.MaxParticles > 0 && len(s.Particles) >= s.MaxParticles
+}
+
+// grow returns buf resized to n, reallocated with some headroom when it is too small so
+// a steady stream of new particles does not reallocate every frame. The contents are
+// only kept when keep is set.
+func grow[T any](buf []T, n int, keep bool) []T {
+	if cap(buf) >= n {
+		return buf[:n]
+	}
+	grown := make([]T, n, n+n/4+256)
+	if keep {
+		copy(grown, buf)
+	}
+	return grown
+}
+
 func (s *Simulation) ParallelFor(action func(start, end int)) {
 	count := len(s.Particles)
 	if count == 0 {
@@ -58,75 +230,193 @@ func (s *Simulation) ParallelFor(action func(start, end int)) {
 	}
 
 	// if too few particles, run serial to avoid scheduler overhead
-	if count < 1000 {
+	if count < 1000 || s.Pool.Workers == 1 {
 		action(0, count)
 		return
 	}
 
-	var wg sync.WaitGroup
-	chunkSize := (count + s.NumCPU - 1) / s.NumCPU
-
-	for i := 0; i < s.NumCPU; i++ {
-		start := i * chunkSize
-		if start >= count {
-			break
-		}
-		end := start + chunkSize
-		if end > count {
-			end = count
-		}
+	s.Pool.Run(count, action)
+}
 
-		wg.Add(1)
-		go func(s, e int) {
-			defer wg.Done()
-			action(s, e)
-		}(start, end)
+// Close stops the Run loop if it is running and shuts down the worker pool. A Run
+// called afterwards returns right away.
+func (s *Simulation) Close() {
+	s.lifecycle.Lock()
+	s.closed = true
+	started := s.started
+	s.lifecycle.Unlock()
+
+	close(s.quit)
+	if started {
+		<-s.done
 	}
-	wg.Wait()
+	s.Pool.Stop()
 }
 
-func (s *Simulation) Resize(termW, termH int) {
+// DomainSize returns the simulation domain that fits a terminal of the given size
+func DomainSize(termW, termH int) (int, int) {
 	if termW <= SidebarWidth {
 		termW = SidebarWidth + 10
 	}
+	return termW - SidebarWidth, termH
+}
 
-	newWidth := termW - SidebarWidth
-	newHeight := termH
+func (s *Simulation) Resize(width, height int) {
+	oldWidth := s.Width
+	oldHeight := s.Height
+	oldWalls := s.Walls
+	oldHeat := s.Heat
+	oldMaterials := s.WallMaterials
+
+	s.setSize(width, height)
+	s.Walls = cropCells(oldWalls, oldWidth, oldHeight, s.Width, s.Height)
+	s.Heat = cropCells(oldHeat, oldWidth, oldHeight, s.Width, s.Height)
+	s.WallMaterials = cropCells(oldMaterials, oldWidth, oldHeight, s.Width, s.Height)
+	s.updateHeatFlag()
+	s.setSources(s.Sources)
+	s.clampParticles()
+	s.clampBodies()
+}
 
+// Rescale changes the domain resolution, walls are resampled and particles scaled along
+func (s *Simulation) Rescale(width, height int) {
 	oldWidth := s.Width
 	oldHeight := s.Height
 	oldWalls := s.Walls
+	oldHeat := s.Heat
+	oldMaterials := s.WallMaterials
+
+	s.setSize(width, height)
+	s.Walls = resampleCells(oldWalls, oldWidth, oldHeight, width, height)
+	s.Heat = resampleCells(oldHeat, oldWidth, oldHeight, width, height)
+	s.WallMaterials = resampleCells(oldMaterials, oldWidth, oldHeight, width, height)
+	s.updateHeatFlag()
+
+	if oldWidth > 0 && oldHeight > 0 {
+		sx := float64(width) / float64(oldWidth)
+		sy := float64(height) / float64(oldHeight)
+		for i := range s.Particles {
+			p := &s.Particles[i]
+			p.Pos.X *= sx
+			p.Pos.Y *= sy
+			p.OldPos.X *= sx
+			p.OldPos.Y *= sy
+		}
+		s.scaleSources(sx, sy)
+		s.scaleBodies(sx, sy)
+		// rest lengths do not survive a non-uniform scale, springs form again right away
+		s.ClearSprings()
+	} else {
+		s.setSources(s.Sources)
+		s.clampBodies()
+	}
+	s.clampParticles()
+}
+
+// LoadState replaces walls and particles with a state saved at a possibly different size,
+// walls are cropped and particles clamped the same way Resize does it
+func (s *Simulation) LoadState(width, height int, walls []bool, heat []int8, materials []WallMaterial, particles []Particle) {
+	s.Walls = cropCells(walls, width, height, s.Width, s.Height)
+	s.Heat = cropCells(heat, width, height, s.Width, s.Height)
+	s.WallMaterials = cropCells(materials, width, height, s.Width, s.Height)
+	s.updateHeatFlag()
+	s.wallsChanged()
+
+	s.Particles = s.Particles[:0]
+	s.ClearSprings()
+	for _, p := range particles {
+		if s.full() {
+			break
+		}
+		if p.Species < 0 || p.Species >= len(s.Species) {
+			p.Species = 0
+		}
+		s.Particles = append(s.Particles, p)
+	}
+	s.clampParticles()
+}
+
+// FillBlock resizes the simulation to fit count particles, one per cell in the lower
+// part of a domain of the given height, stored in random order like after a long
+// session of spawning and draining. It returns the domain width.
+func (s *Simulation) FillBlock(count, height int) int {
+	rows := max(height*6/10, 1)
+	cols := (count + rows - 1) / rows
+	width := cols + 4
+
+	rng := rand.New(rand.NewSource(1))
+	particles := make([]Particle, count)
+	for i, slot := range rng.Perm(count) {
+		x := 2 + float64(slot%cols) + rng.Float64()*0.5
+		y := float64(height-2-slot/cols) - rng.Float64()*0.5
+		particles[i] = Particle{
+			Pos:    Vector{X: x, Y: y},
+			OldPos: Vector{X: x, Y: y},
+			Temp:   s.Config.AmbientTemp,
+		}
+	}
+	s.Resize(width, height)
+	s.LoadState(width, height, make([]bool, width*height), make([]int8, width*height), nil, particles)
+	return width
+}
 
-	s.Width = newWidth
-	s.Height = newHeight
+func (s *Simulation) setSize(width, height int) {
+	s.Width = width
+	s.Height = height
+	s.wallsChanged()
 
 	s.GridCols = (s.Width >> CellShift) + 1
 	s.GridRows = (s.Height >> CellShift) + 1
 
 	totalCells := s.GridCols * s.GridRows
-	s.GridHeads = make([]int, totalCells)
-	s.Walls = make([]bool, s.Width*s.Height)
+	s.CellStart = make([]int, totalCells+1)
+	s.cellFill = make([]int, totalCells+1)
+	s.updateGridReach()
+}
+
+// cropCells copies a per-cell grid into a new size, keeping the top left corner
+func cropCells[T any](oldCells []T, oldWidth, oldHeight, width, height int) []T {
+	cells := make([]T, width*height)
 
-	if len(oldWalls) > 0 && oldWidth > 0 {
+	if len(oldCells) == oldWidth*oldHeight && oldWidth > 0 {
 		minH := oldHeight
-		if s.Height < minH {
-			minH = s.Height
+		if height < minH {
+			minH = height
 		}
 		minW := oldWidth
-		if s.Width < minW {
-			minW = s.Width
+		if width < minW {
+			minW = width
 		}
 
 		for y := 0; y < minH; y++ {
 			srcStart := y * oldWidth
-			destStart := y * s.Width
-			copy(s.Walls[destStart:destStart+minW], oldWalls[srcStart:srcStart+minW])
+			destStart := y * width
+			copy(cells[destStart:destStart+minW], oldCells[srcStart:srcStart+minW])
 		}
 	}
 
-	limitX := float64(s.Width) - 1.1
-	limitY := float64(s.Height) - 1.1
-	margin := 1.1
+	return cells
+}
+
+// resampleCells scales a per-cell grid to a new size with nearest neighbor sampling
+func resampleCells[T any](oldCells []T, oldWidth, oldHeight, width, height int) []T {
+	cells := make([]T, width*height)
+	if len(oldCells) == oldWidth*oldHeight && oldWidth > 0 && oldHeight > 0 {
+		for y := 0; y < height; y++ {
+			srcY := y * oldHeight / height
+			for x := 0; x < width; x++ {
+				srcX := x * oldWidth / width
+				cells[x+y*width] = oldCells[srcX+srcY*oldWidth]
+			}
+		}
+	}
+	return cells
+}
+
+func (s *Simulation) clampParticles() {
+	limitX := float64(s.Width) - edgeMargin
+	limitY := float64(s.Height) - edgeMargin
+	margin := edgeMargin
 
 	for i := range s.Particles {
 		p := &s.Particles[i]
@@ -150,55 +440,231 @@ func (s *Simulation) Resize(termW, termH int) {
 }
 
 func (s *Simulation) Run() {
-	ticker := time.NewTicker(time.Millisecond * 16)
+	s.lifecycle.Lock()
+	if s.closed {
+		s.lifecycle.Unlock()
+		return
+	}
+	s.started = true
+	s.lifecycle.Unlock()
+	defer close(s.done)
+
+	interval := s.StepInterval()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	snapshot := make([]render.Point, 0, MaxParticles)
+	// backlog is the real time not simulated yet, scaled for fast forward. A long step
+	// delays the ticks, the following ones catch up on it.
+	last := time.Now()
+	backlog := time.Duration(0)
+	calcTime := time.Duration(0)
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-s.quit:
+			return
+		}
 
-	for range ticker.C {
-	ProcessCommands:
-		for {
-			select {
-			case cmd := <-s.CmdChan:
-				cmd(s)
-			default:
-				break ProcessCommands
+		s.ProcessCommands()
+		backlog += time.Duration(float64(now.Sub(last)) * max(s.TimeScale, 1))
+		last = now
+
+		steps := 0
+		tickTime := time.Duration(0)
+		for ; backlog >= interval; backlog -= interval {
+			// catching up must fit into the tick, or the next tick has even more to catch up on
+			if steps == maxStepsPerTick || (steps > 0 && time.Since(now) >= interval) {
+				backlog = 0
+				break
+			}
+			// recorded commands are due at the frame they were applied at
+			if steps > 0 {
+				s.applyReplay()
 			}
+			tickTime += s.Step()
+			steps++
 		}
+		if steps > 0 {
+			calcTime = tickTime
+		}
+		s.publishFrame(calcTime)
+	}
+}
 
-		start := time.Now()
+// publishFrame fills a free frame and sends it to the renderer. A frame the renderer has
+// not picked up yet is replaced, so it always gets the latest one. While all frames are
+// in use this tick is not shown.
+func (s *Simulation) publishFrame(calcTime time.Duration) {
+	var f *render.FrameSnapshot
+	select {
+	case f = <-s.freeFrames:
+	default:
+		return
+	}
+
+	f.Points = s.AppendPoints(f.Points[:0])
+	f.Sources = s.AppendSources(f.Sources[:0])
+	f.Bodies = s.AppendBodies(f.Bodies[:0])
+	f.Walls = s.wallSnapshot()
+	f.CalcTime = calcTime
+	f.Frame = s.Frame
+	f.Width, f.Height = s.Width, s.Height
+	f.MaxParticles = s.MaxParticles
+	f.StepInterval = s.StepInterval()
+	f.TimeScale = s.TimeScale
+	f.SubSteps = s.subSteps
+	f.Simulated = s.Simulated
+	f.SubStep = s.substep
+	f.Rewind = s.rewindPos
+	f.RewindLen = s.rewind.count
+	f.Recording = s.Recorder != nil
+	f.RecordErr = s.recordErr
+	f.Replaying = s.Replaying
+
+	select {
+	case s.RenderChan <- f:
+		return
+	default:
+	}
+	select {
+	case stale := <-s.RenderChan:
+		s.ReleaseFrame(stale)
+	default:
+	}
+	// Run is the only sender, so there is room now
+	s.RenderChan <- f
+}
 
-		if !s.Config.IsPaused {
-			dt := 1.0 / float64(SubSteps)
-			for step := 0; step < SubSteps; step++ {
-				s.UpdateSpatialHash()
-				s.Integration(dt)
-				s.SolveViscosity()
-				s.SolveFluid()
-				s.EnforceBoundaries()
+// ReleaseFrame hands a frame received from RenderChan back once the renderer is done
+// with it, its buffers are reused for a later frame
+func (s *Simulation) ReleaseFrame(f *render.FrameSnapshot) {
+	if f == nil {
+		return
+	}
+	select {
+	case s.freeFrames <- f:
+	default:
+	}
+}
+
+// ProcessCommands applies all queued commands without blocking.
+// While replaying, live commands are dropped in favor of the recorded ones.
+func (s *Simulation) ProcessCommands() {
+	for {
+		select {
+		case ctl := <-s.ControlChan:
+			ctl(s)
+		case cmd := <-s.CmdChan:
+			if !s.Replaying {
+				s.Apply(cmd)
 			}
+		default:
+			s.applyReplay()
+			return
 		}
+	}
+}
 
-		calcTime := time.Since(start)
+func (s *Simulation) applyReplay() {
+	for len(s.replay) > 0 && s.replay[0].Frame <= s.Frame {
+		s.Apply(s.replay[0])
+		s.replay = s.replay[1:]
+	}
+	if len(s.replay) == 0 {
+		s.Replaying = false
+	}
+}
 
-		// render snapshot
-		snapshot = snapshot[:0]
-		for i := range s.Particles {
-			p := s.Particles[i]
-			snapshot = append(snapshot, render.Point{X: int(p.Pos.X), Y: int(p.Pos.Y)})
+// Step advances the simulation by one frame and returns the time spent in the solver
+func (s *Simulation) Step() time.Duration {
+	start := time.Now()
+
+	if !s.Config.IsPaused {
+		for !s.advanceSubstep() {
 		}
+	}
 
-		pointsCopy := make([]render.Point, len(snapshot))
-		copy(pointsCopy, snapshot)
+	calcTime := time.Since(start)
+	s.Frame++
+	return calcTime
+}
 
-		select {
-		case s.RenderChan <- render.FrameSnapshot{Points: pointsCopy, CalcTime: calcTime}:
-		default:
+// advanceSubstep runs the next substep of the current frame. The first one begins the
+// frame and the last one ends it, which is when it returns true. Frames stepped substep
+// by substep while paused end up the same as whole ones.
+func (s *Simulation) advanceSubstep() bool {
+	if s.rewindPos > 0 {
+		s.dropFuture()
+	}
+	// walls may change between the substeps of a paused frame
+	if s.wallsDirty {
+		s.updateWallField()
+	}
+	if s.substep == 0 {
+		if s.SortInterval > 0 && s.Frame%uint64(s.SortInterval) == 0 {
+			s.sortParticles()
 		}
+		s.UpdateMaterials()
+		s.Emit()
+		s.planSubsteps()
+	}
+
+	dt := s.subDt
+	s.UpdateSpatialHash()
+	s.ApplyForces(dt)
+	s.Integration(dt)
+	s.SolveViscosity()
+	s.SolveSprings(s.substep == 0)
+	s.SolveFluid()
+	s.SolveBodies(dt)
+	s.EnforceBoundaries()
+
+	s.substep++
+	if s.substep < s.subSteps {
+		return false
 	}
+	s.substep = 0
+	s.Drain()
+	s.UpdateTemperature()
+	s.Simulated++
+	s.saveRewind()
+	return true
 }
 
-func (s *Simulation) Spawn(x, y float64) {
+func (s *Simulation) AppendPoints(points []render.Point) []render.Point {
+	// speeds are shown per default substep whatever the substeps are now
+	toDefault := 1 / s.velocityScale()
+	for i := range s.Particles {
+		p := s.Particles[i]
+		vx := p.Pos.X - p.OldPos.X
+		vy := p.Pos.Y - p.OldPos.Y
+		point := render.Point{
+			X:       int(p.Pos.X),
+			Y:       int(p.Pos.Y),
+			Species: p.Species,
+			Speed:   float32(math.Sqrt(vx*vx+vy*vy) * toDefault),
+			Temp:    float32(p.Temp),
+		}
+		// particles spawned since the last step have no solver values yet
+		if i < len(s.Density) {
+			point.Density = float32(s.Density[i])
+			point.NearDensity = float32(s.NearDensity[i])
+			point.Pressure = float32(s.Pressure[i])
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+// Spawn adds particles around x, y spread over an area of width by height cells, at
+// least the default 6 by 4. Larger areas spawn proportionally more particles, up to
+// maxSpawn.
+func (s *Simulation) Spawn(x, y float64, width, height, species int) {
+	if species < 0 || species >= len(s.Species) {
+		species = 0
+	}
+
 	ix, iy := int(x), int(y)
 	if uint(ix) < uint(s.Width) && uint(iy) < uint(s.Height) {
 		if s.Walls[ix+iy*s.Width] {
@@ -206,18 +672,23 @@ func (s *Simulation) Spawn(x, y float64) {
 		}
 	}
 
-	for i := 0; i < s.Config.SpawnCount; i++ {
-		if len(s.Particles) >= MaxParticles {
+	width, height = max(width, 6), max(height, 4)
+	w, h := float64(width), float64(height)
+	count := min(s.Config.SpawnCount*width*height/24, maxSpawn)
+	for i := 0; i < count; i++ {
+		if s.full() {
 			break
 		}
-		jx := x + (rand.Float64()*6 - 3)
-		jy := y + (rand.Float64()*4 - 2)
+		jx := x + (s.Rand.Float64()*w - w/2)
+		jy := y + (s.Rand.Float64()*h - h/2)
 
 		p := Particle{
-			Pos:    Vector{X: jx, Y: jy},
-			OldPos: Vector{X: jx, Y: jy},
+			Pos:     Vector{X: jx, Y: jy},
+			OldPos:  Vector{X: jx, Y: jy},
+			Species: species,
+			Temp:    s.spawnTemp(species),
 		}
-		p.OldPos.Y -= 0.5
+		p.OldPos.Y -= 0.5 * s.velocityScale()
 		s.Particles = append(s.Particles, p)
 	}
 }
@@ -225,5 +696,10 @@ func (s *Simulation) Spawn(x, y float64) {
 func (s *Simulation) SetWall(x, y int, isWall bool) {
 	if uint(x) < uint(s.Width) && uint(y) < uint(s.Height) {
 		s.Walls[x+y*s.Width] = isWall
+		s.wallsChanged()
+		if !isWall {
+			s.Heat[x+y*s.Width] = HeatNone
+			s.WallMaterials[x+y*s.Width] = WallDefault
+		}
 	}
 }
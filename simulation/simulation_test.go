@@ -0,0 +1,65 @@
+package simulation
+
+import (
+	"testing"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/config"
+)
+
+// TestCloseRacesRun closes simulations while their Run loop is starting, run it with
+// -race to check the two agree on whether Run has to be waited for
+func TestCloseRacesRun(t *testing.T) {
+	cfg := config.NewDefaultConfig().Presets["Default"]
+	for range 50 {
+		sim := NewSimulation(20, 10, cfg, nil)
+		go sim.Run()
+		sim.Close()
+	}
+
+	// a Run after Close returns instead of waiting for a quit that already happened
+	sim := NewSimulation(20, 10, cfg, nil)
+	sim.Close()
+	sim.Run()
+}
+
+// TestWorkersDeterministic steps the same seeded scene with one and with several
+// workers, the double buffered passes have to give bitwise identical particles
+func TestWorkersDeterministic(t *testing.T) {
+	cfg := config.NewDefaultConfig().Presets["Default"]
+	cfg.Seed = 7
+	run := func(workers int) []Particle {
+		sim := NewSimulation(1, 1, cfg, nil)
+		defer sim.Close()
+		sim.SetWorkers(workers)
+		sim.FillBlock(3000, 40)
+		sim.Config.IsPaused = false
+		for frame := range 40 {
+			if frame%10 == 0 {
+				sim.Spawn(20, 5, 12, 4, 0)
+			}
+			sim.Step()
+		}
+		return sim.Particles
+	}
+
+	serial, parallel := run(1), run(4)
+	if len(serial) != len(parallel) {
+		t.Fatalf("%d particles with one worker, %d with four", len(serial), len(parallel))
+	}
+	for i := range serial {
+		if serial[i] != parallel[i] {
+			t.Fatalf("particle %d differs: %+v with one worker, %+v with four", i, serial[i], parallel[i])
+		}
+	}
+}
+
+// TestStepCountCapped applies a step command with a count no session produces, as a
+// corrupt recording could contain
+func TestStepCountCapped(t *testing.T) {
+	sim := NewSimulation(20, 10, config.NewDefaultConfig().Presets["Default"], nil)
+	defer sim.Close()
+	sim.Apply(Command{Kind: CmdStepFrame, Count: 1 << 40})
+	if sim.Simulated != MaxStepFrames {
+		t.Errorf("stepped %d frames, want %d", sim.Simulated, MaxStepFrames)
+	}
+}
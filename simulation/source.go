@@ -0,0 +1,219 @@
+package simulation
+
+import (
+	"math"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/render"
+)
+
+type SourceKind string
+
+const (
+	SourceEmitter SourceKind = "emitter"
+	SourceDrain   SourceKind = "drain"
+)
+
+// Source is a placed object covering a block of cells. Emitters add Rate particles per
+// frame moving at Speed cells per frame in the Angle direction (degrees, 0 points right,
+// 90 down), drains delete every particle that enters their block.
+type Source struct {
+	Kind    SourceKind `json:"kind"`
+	X       int        `json:"x"`
+	Y       int        `json:"y"`
+	Width   int        `json:"w"`
+	Height  int        `json:"h"`
+	Species int        `json:"species,omitempty"`
+	Rate    float64    `json:"rate,omitempty"`
+	Angle   float64    `json:"angle,omitempty"`
+	Speed   float64    `json:"speed,omitempty"`
+}
+
+// AddSource places src clipped to the domain, replacing any source on the same block.
+// A source outside the domain is dropped.
+func (s *Simulation) AddSource(src Source) {
+	x0, y0 := max(src.X, 0), max(src.Y, 0)
+	x1, y1 := min(src.X+src.Width, s.Width), min(src.Y+src.Height, s.Height)
+	src.X, src.Y, src.Width, src.Height = x0, y0, x1-x0, y1-y0
+	if src.Width < 1 || src.Height < 1 {
+		return
+	}
+	for i := range s.Sources {
+		o := &s.Sources[i]
+		if o.X == src.X && o.Y == src.Y && o.Width == src.Width && o.Height == src.Height {
+			*o = src
+			s.updateDrainMask()
+			return
+		}
+	}
+	s.Sources = append(s.Sources, src)
+	s.updateDrainMask()
+}
+
+// RemoveSources deletes every source overlapping the block
+func (s *Simulation) RemoveSources(x, y, width, height int) {
+	n := 0
+	for _, src := range s.Sources {
+		if src.X < x+width && x < src.X+src.Width && src.Y < y+height && y < src.Y+src.Height {
+			continue
+		}
+		s.Sources[n] = src
+		n++
+	}
+	if n != len(s.Sources) {
+		s.Sources = s.Sources[:n]
+		s.updateDrainMask()
+	}
+}
+
+func (s *Simulation) setSources(sources []Source) {
+	s.Sources = s.Sources[:0]
+	for _, src := range sources {
+		if src.X >= 0 && src.Y >= 0 && src.X+src.Width <= s.Width && src.Y+src.Height <= s.Height {
+			s.Sources = append(s.Sources, src)
+		}
+	}
+	s.updateDrainMask()
+}
+
+// scaleSources moves the sources to a domain rescaled by sx, sy
+func (s *Simulation) scaleSources(sx, sy float64) {
+	sources := s.Sources
+	for i := range sources {
+		src := &sources[i]
+		src.X = int(float64(src.X) * sx)
+		src.Y = int(float64(src.Y) * sy)
+		src.Width = max(1, int(math.Round(float64(src.Width)*sx)))
+		src.Height = max(1, int(math.Round(float64(src.Height)*sy)))
+	}
+	s.setSources(sources)
+}
+
+func (s *Simulation) updateDrainMask() {
+	if len(s.drainMask) != s.Width*s.Height {
+		s.drainMask = make([]bool, s.Width*s.Height)
+	} else {
+		clear(s.drainMask)
+	}
+
+	s.hasDrains = false
+	for _, src := range s.Sources {
+		if src.Kind != SourceDrain {
+			continue
+		}
+		s.hasDrains = true
+		for y := src.Y; y < src.Y+src.Height; y++ {
+			for x := src.X; x < src.X+src.Width; x++ {
+				if uint(x) < uint(s.Width) && uint(y) < uint(s.Height) {
+					s.drainMask[x+y*s.Width] = true
+				}
+			}
+		}
+	}
+}
+
+// Emit adds the particles of every emitter for one frame
+func (s *Simulation) Emit() {
+	for i := range s.Sources {
+		src := &s.Sources[i]
+		if src.Kind != SourceEmitter {
+			continue
+		}
+
+		species := src.Species
+		if species < 0 || species >= len(s.Species) {
+			species = 0
+		}
+		angle := src.Angle * math.Pi / 180
+		// scaled to the current substep and step length
+		speed := src.Speed * s.velocityScale()
+		vx, vy := math.Cos(angle)*speed, math.Sin(angle)*speed
+		rate := src.Rate * s.frameLength()
+
+		// fractional rates are spread over frames by the frame counter so no
+		// remainder has to be saved with the state
+		count := int(math.Floor(float64(s.Frame+1)*rate) - math.Floor(float64(s.Frame)*rate))
+		for ; count > 0 && !s.full(); count-- {
+			x := float64(src.X) + s.Rand.Float64()*float64(src.Width)
+			y := float64(src.Y) + s.Rand.Float64()*float64(src.Height)
+			ix, iy := int(x), int(y)
+			if uint(ix) >= uint(s.Width) || uint(iy) >= uint(s.Height) || s.Walls[ix+iy*s.Width] {
+				continue
+			}
+			s.Particles = append(s.Particles, Particle{
+				Pos:     Vector{X: x, Y: y},
+				OldPos:  Vector{X: x - vx, Y: y - vy},
+				Species: species,
+				Temp:    s.spawnTemp(species),
+			})
+		}
+	}
+}
+
+// Drain removes the particles inside drain blocks and the ones leaving through open edges
+func (s *Simulation) Drain() {
+	open := s.hasOpenEdges()
+	if !s.hasDrains && !open {
+		return
+	}
+	s.RemoveParticles(func(p *Particle) bool {
+		if open && s.pastOpenEdge(p) {
+			return true
+		}
+		x, y := int(p.Pos.X), int(p.Pos.Y)
+		return s.hasDrains && uint(x) < uint(s.Width) && uint(y) < uint(s.Height) && s.drainMask[x+y*s.Width]
+	})
+}
+
+// RemoveParticles deletes the particles matching drop, keeping the order of the rest
+// and of their per-particle solver values
+func (s *Simulation) RemoveParticles(drop func(p *Particle) bool) {
+	fields := len(s.Density) == len(s.Particles)
+	var remap []int
+	if len(s.Springs) > 0 {
+		s.particleRemap = grow(s.particleRemap, len(s.Particles), false)
+		remap = s.particleRemap
+	}
+	n := 0
+	for i := range s.Particles {
+		if drop(&s.Particles[i]) {
+			if remap != nil {
+				remap[i] = -1
+			}
+			continue
+		}
+		if remap != nil {
+			remap[i] = n
+		}
+		s.Particles[n] = s.Particles[i]
+		if fields {
+			s.Density[n] = s.Density[i]
+			s.NearDensity[n] = s.NearDensity[i]
+			s.Pressure[n] = s.Pressure[i]
+		}
+		n++
+	}
+	s.Particles = s.Particles[:n]
+	if fields {
+		s.Density = s.Density[:n]
+		s.NearDensity = s.NearDensity[:n]
+		s.Pressure = s.Pressure[:n]
+	}
+	if remap != nil {
+		s.remapSprings(remap)
+	}
+}
+
+// AppendSources copies the sources for the renderer
+func (s *Simulation) AppendSources(sources []render.Source) []render.Source {
+	for _, src := range s.Sources {
+		sources = append(sources, render.Source{
+			X:      src.X,
+			Y:      src.Y,
+			Width:  src.Width,
+			Height: src.Height,
+			Drain:  src.Kind == SourceDrain,
+			Angle:  src.Angle,
+		})
+	}
+	return sources
+}
@@ -0,0 +1,74 @@
+package simulation
+
+// material is a species entry resolved against the active config
+type material struct {
+	invMass     float64 // 0 for static species
+	restDensity float64
+	viscosity   float64
+	static      bool
+	lift        float64
+
+	coolBelow, heatAbove float64
+	coolInto, heatInto   int // species index, -1 for no phase change
+}
+
+// UpdateMaterials resolves the species table, unset values fall back to the preset
+func (s *Simulation) UpdateMaterials() {
+	s.materials = s.materials[:0]
+	for _, sp := range s.Species {
+		m := material{
+			invMass:     1.0,
+			restDensity: s.Config.RestDensity,
+			viscosity:   s.Config.Viscosity,
+			static:      sp.Static,
+			lift:        sp.Lift,
+			coolBelow:   sp.CoolBelow,
+			heatAbove:   sp.HeatAbove,
+			coolInto:    s.speciesIndex(sp.CoolInto),
+			heatInto:    s.speciesIndex(sp.HeatInto),
+		}
+		if sp.Mass > 0 {
+			m.invMass = 1.0 / sp.Mass
+		}
+		if sp.Static {
+			m.invMass = 0
+		}
+		if sp.RestDensity != 0 {
+			m.restDensity = sp.RestDensity
+		}
+		if sp.Viscosity != 0 {
+			m.viscosity = sp.Viscosity
+		}
+		s.materials = append(s.materials, m)
+	}
+}
+
+func (s *Simulation) speciesIndex(name string) int {
+	if name == "" {
+		return -1
+	}
+	for i, sp := range s.Species {
+		if sp.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// spawnTemp is the temperature new particles of a species start with
+func (s *Simulation) spawnTemp(species int) float64 {
+	if t := s.Species[species].Temperature; t != 0 {
+		return t
+	}
+	return s.Config.AmbientTemp
+}
+
+// pairShare is the part of a pair correction the first particle takes, the lighter
+// particle takes more and static particles none
+func pairShare(invMassI, invMassJ float64) float64 {
+	sum := invMassI + invMassJ
+	if sum == 0 {
+		return 0
+	}
+	return invMassI / sum
+}
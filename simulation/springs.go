@@ -0,0 +1,222 @@
+package simulation
+
+import "math"
+
+const (
+	// springCapRatio bounds the spring storage relative to the particle count, new
+	// springs are skipped once it is full
+	springCapRatio = 8
+	// maxSpringMove limits the displacement of one spring per substep
+	maxSpringMove = 0.5
+	// minRestRatio is the shortest rest length of a new spring relative to the interaction radius
+	minRestRatio = 0.4
+	// tearRatio is the length relative to the interaction radius where springs snap
+	tearRatio = 2
+	// maxSpringsPerParticle keeps compressed clumps from growing hundreds of springs each
+	maxSpringsPerParticle = 12
+)
+
+// Spring connects two particles of the same species, I < J. Rest is the current rest
+// length, it yields towards the actual distance once stretched or compressed by more
+// than YieldRatio.
+type Spring struct {
+	I    int     `json:"i"`
+	J    int     `json:"j"`
+	Rest float64 `json:"rest"`
+}
+
+func springKey(i, j int) uint64 {
+	return uint64(i)<<32 | uint64(uint32(j))
+}
+
+// SolveSprings runs the viscoelastic spring model: springs are created between close
+// particles, their rest lengths adapt plastically and they pull their ends towards the
+// rest length. The pass is serial, springs are updated in creation order. New springs
+// are only looked for when create is set, once per frame is enough.
+func (s *Simulation) SolveSprings(create bool) {
+	if s.Config.SpringStiffness == 0 {
+		if len(s.Springs) > 0 {
+			s.ClearSprings()
+		}
+		return
+	}
+
+	if create {
+		s.createSprings()
+	}
+	s.relaxSprings()
+}
+
+func (s *Simulation) ClearSprings() {
+	s.Springs = s.Springs[:0]
+	clear(s.springSet)
+}
+
+func (s *Simulation) createSprings() {
+	if s.springSet == nil {
+		s.springSet = make(map[uint64]struct{})
+	}
+
+	cols := s.GridCols
+	radSq := s.Config.InteractionRadSq
+	minRest := s.Config.InteractionRad * minRestRatio
+	wrap := s.seams()
+	wrapped := wrap.any()
+	maxSprings := len(s.Particles) * springCapRatio
+
+	s.springDegree = grow(s.springDegree, len(s.Particles), false)
+	degree := s.springDegree
+	clear(degree)
+	for _, sp := range s.Springs {
+		degree[sp.I]++
+		degree[sp.J]++
+	}
+
+	for i := range s.Particles {
+		if len(s.Springs) >= maxSprings {
+			return
+		}
+		p := &s.Particles[i]
+		gx, gy := s.gridCell(p.Pos)
+
+		for _, y := range s.reachY[gy] {
+			row := y * cols
+			for _, span := range s.reachX[gx] {
+				for k := s.CellStart[row+span.first]; k < s.CellStart[row+span.last+1] && degree[i] < maxSpringsPerParticle; k++ {
+					j := s.GridOrder[k]
+					if j <= i || degree[j] >= maxSpringsPerParticle {
+						continue
+					}
+					pj := &s.Particles[j]
+					if pj.Species != p.Species {
+						continue
+					}
+					dx := pj.Pos.X - p.Pos.X
+					dy := pj.Pos.Y - p.Pos.Y
+					if wrapped {
+						dx, dy = wrap.nearest(dx, dy)
+					}
+					rSq := dx*dx + dy*dy
+					if rSq >= radSq {
+						continue
+					}
+
+					key := springKey(i, j)
+					if _, ok := s.springSet[key]; ok {
+						continue
+					}
+					s.springSet[key] = struct{}{}
+					// overlapping particles get a spring that pushes them apart, a rest
+					// length the pressure solve fights forever makes springs unstable
+					rest := math.Max(math.Sqrt(rSq), minRest)
+					s.Springs = append(s.Springs, Spring{I: i, J: j, Rest: rest})
+					degree[i]++
+					degree[j]++
+				}
+			}
+		}
+	}
+}
+
+func (s *Simulation) relaxSprings() {
+	rad := s.Config.InteractionRad
+	invRad := s.Config.InvInteractionRad
+	stiffness := s.Config.SpringStiffness
+	yield := s.Config.YieldRatio
+	plasticity := s.Config.Plasticity
+	mats := s.materials
+	wrap := s.seams()
+	wrapped := wrap.any()
+
+	n := 0
+	for _, sp := range s.Springs {
+		pi := &s.Particles[sp.I]
+		pj := &s.Particles[sp.J]
+		dx := pj.Pos.X - pi.Pos.X
+		dy := pj.Pos.Y - pi.Pos.Y
+		if wrapped {
+			dx, dy = wrap.nearest(dx, dy)
+		}
+		r := math.Sqrt(dx*dx + dy*dy)
+
+		// plastic deformation beyond the yield range
+		d := yield * sp.Rest
+		if r > sp.Rest+d {
+			sp.Rest += plasticity * (r - sp.Rest - d)
+		} else if r < sp.Rest-d {
+			sp.Rest -= plasticity * (sp.Rest - d - r)
+		}
+
+		// springs stretched past the interaction radius break, elastic ones tear
+		// once pulled far apart
+		if sp.Rest > rad || r > rad*tearRatio {
+			delete(s.springSet, springKey(sp.I, sp.J))
+			continue
+		}
+		s.Springs[n] = sp
+		n++
+
+		if r < 1e-4 {
+			continue
+		}
+		// clamped like the pressure correction so stacked particles cannot explode
+		move := stiffness * (1 - sp.Rest*invRad) * (sp.Rest - r)
+		move = math.Max(-maxSpringMove, math.Min(maxSpringMove, move)) / r
+		invI, invJ := mats[pi.Species].invMass, mats[pj.Species].invMass
+		shareI, shareJ := pairShare(invI, invJ), pairShare(invJ, invI)
+		mx, my := dx*move, dy*move
+
+		s.moveSpringEnd(pi, -mx*shareI, -my*shareI)
+		s.moveSpringEnd(pj, mx*shareJ, my*shareJ)
+	}
+	s.Springs = s.Springs[:n]
+}
+
+// moveSpringEnd displaces a particle unless that would block it
+func (s *Simulation) moveSpringEnd(p *Particle, dx, dy float64) {
+	x, y := p.Pos.X+dx, p.Pos.Y+dy
+	if s.isBlocked(x, y) {
+		return
+	}
+	p.Pos.X, p.Pos.Y = x, y
+}
+
+// remapSprings rewrites spring ends after particles were removed or reordered, remap
+// holds the new index of every old particle or -1 for removed ones
+func (s *Simulation) remapSprings(remap []int) {
+	clear(s.springSet)
+	n := 0
+	for _, sp := range s.Springs {
+		i, j := remap[sp.I], remap[sp.J]
+		if i < 0 || j < 0 {
+			continue
+		}
+		if i > j {
+			i, j = j, i
+		}
+		sp.I, sp.J = i, j
+		s.springSet[springKey(i, j)] = struct{}{}
+		s.Springs[n] = sp
+		n++
+	}
+	s.Springs = s.Springs[:n]
+}
+
+// setSprings loads saved springs, skipping invalid ones
+func (s *Simulation) setSprings(springs []Spring) {
+	s.ClearSprings()
+	if s.springSet == nil {
+		s.springSet = make(map[uint64]struct{})
+	}
+	for _, sp := range springs {
+		if sp.I < 0 || sp.J >= len(s.Particles) || sp.I >= sp.J || len(s.Springs) >= len(s.Particles)*springCapRatio {
+			continue
+		}
+		key := springKey(sp.I, sp.J)
+		if _, ok := s.springSet[key]; ok {
+			continue
+		}
+		s.springSet[key] = struct{}{}
+		s.Springs = append(s.Springs, sp)
+	}
+}
@@ -0,0 +1,56 @@
+package simulation
+
+import (
+	"testing"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/config"
+)
+
+// newTagged returns a simulation with count particles in a row, each tagged with its
+// starting index in Temp so tests can follow it through reorders and removals
+func newTagged(t *testing.T, count int) *Simulation {
+	t.Helper()
+	sim := NewSimulation(count+10, 20, config.NewDefaultConfig().Presets["Default"], nil)
+	t.Cleanup(sim.Close)
+	particles := make([]Particle, count)
+	for i := range particles {
+		pos := Vector{X: float64(i) + 3, Y: 10}
+		particles[i] = Particle{Pos: pos, OldPos: pos, Temp: float64(i)}
+	}
+	sim.LoadState(sim.Width, sim.Height, make([]bool, sim.Width*sim.Height), make([]int8, sim.Width*sim.Height), nil, particles)
+	return sim
+}
+
+// tag is the starting index of particle i, see newTagged
+func tag(sim *Simulation, i int) int {
+	return int(sim.Particles[i].Temp)
+}
+
+// TestSpringsSurviveRemoval drains particles at one end of some springs, those springs
+// are dropped and the others still join the same particles
+func TestSpringsSurviveRemoval(t *testing.T) {
+	sim := newTagged(t, 10)
+	sim.setSprings([]Spring{{0, 1, 1}, {1, 2, 1}, {2, 5, 3}, {3, 9, 6}, {4, 8, 4}, {6, 7, 1}})
+
+	sim.RemoveParticles(func(p *Particle) bool { return p.Temp == 1 || p.Temp == 9 })
+
+	want := map[[2]int]bool{{2, 5}: true, {4, 8}: true, {6, 7}: true}
+	if len(sim.Springs) != len(want) {
+		t.Fatalf("%d springs left, want %d: %+v", len(sim.Springs), len(want), sim.Springs)
+	}
+	for _, sp := range sim.Springs {
+		if sp.I >= sp.J {
+			t.Errorf("spring %+v does not have I < J", sp)
+		}
+		ends := [2]int{tag(sim, sp.I), tag(sim, sp.J)}
+		if !want[ends] {
+			t.Errorf("spring %+v joins particles %v, not one of the kept springs", sp, ends)
+		}
+		if _, ok := sim.springSet[springKey(sp.I, sp.J)]; !ok {
+			t.Errorf("spring %+v missing from the spring set", sp)
+		}
+	}
+	if len(sim.springSet) != len(sim.Springs) {
+		t.Errorf("spring set holds %d entries for %d springs", len(sim.springSet), len(sim.Springs))
+	}
+}
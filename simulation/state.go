@@ -0,0 +1,191 @@
+package simulation
+
+import (
+	"fmt"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/config"
+)
+
+const (
+	wallCell  = '#'
+	hotCell   = 'H'
+	coldCell  = 'C'
+	emptyCell = '.'
+)
+
+// materialCells mark the walls of each material, the default ones with wallCell
+var materialCells = [...]byte{
+	WallDefault:  wallCell,
+	WallSlippery: '~',
+	WallSticky:   '%',
+	WallBouncy:   'O',
+}
+
+// State is a serializable copy of the walls, sources, bodies and particles
+type State struct {
+	Width     int             `json:"width"`
+	Height    int             `json:"height"`
+	Walls     []string        `json:"walls"` // one string per row, '#' marks a wall, 'H' and 'C' hot and cold walls, '~', '%' and 'O' slippery, sticky and bouncy walls
+	Sources   []Source        `json:"sources,omitempty"`
+	Particles []ParticleState `json:"particles"`
+	Springs   []Spring        `json:"springs,omitempty"`
+	Bodies    []Body          `json:"bodies,omitempty"`
+	// Species names the species the particle indices refer to, states saved without it
+	// use the indices of the table they are loaded with
+	Species []string `json:"species,omitempty"`
+}
+
+type ParticleState struct {
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+	OldX    float64 `json:"ox"`
+	OldY    float64 `json:"oy"`
+	Species int     `json:"s,omitempty"`
+	Temp    float64 `json:"t,omitempty"`
+}
+
+func (s *Simulation) CaptureState() *State {
+	st := &State{
+		Width:     s.Width,
+		Height:    s.Height,
+		Walls:     make([]string, s.Height),
+		Sources:   append([]Source(nil), s.Sources...),
+		Particles: make([]ParticleState, len(s.Particles)),
+		Springs:   append([]Spring(nil), s.Springs...),
+		Bodies:    append([]Body(nil), s.Bodies...),
+		Species:   make([]string, len(s.Species)),
+	}
+	for i, sp := range s.Species {
+		st.Species[i] = sp.Name
+	}
+
+	row := make([]byte, s.Width)
+	for y := 0; y < s.Height; y++ {
+		for x := 0; x < s.Width; x++ {
+			idx := x + y*s.Width
+			switch {
+			case s.Heat[idx] == HeatHot:
+				row[x] = hotCell
+			case s.Heat[idx] == HeatCold:
+				row[x] = coldCell
+			case s.Walls[idx]:
+				row[x] = materialCells[s.WallMaterials[idx]]
+			default:
+				row[x] = emptyCell
+			}
+		}
+		st.Walls[y] = string(row)
+	}
+
+	for i, p := range s.Particles {
+		st.Particles[i] = ParticleState{
+			X:       p.Pos.X,
+			Y:       p.Pos.Y,
+			OldX:    p.OldPos.X,
+			OldY:    p.OldPos.Y,
+			Species: p.Species,
+			Temp:    p.Temp,
+		}
+	}
+
+	// velocities are saved per default substep
+	if scale := s.velocityScale(); scale != 1 {
+		for i := range st.Particles {
+			p := &st.Particles[i]
+			p.OldX = p.X - (p.X-p.OldX)/scale
+			p.OldY = p.Y - (p.Y-p.OldY)/scale
+		}
+		for i := range st.Bodies {
+			b := &st.Bodies[i]
+			b.VX /= scale
+			b.VY /= scale
+			b.Spin /= scale
+		}
+	}
+
+	return st
+}
+
+// ApplyState loads a captured state, see LoadState for how size differences are handled
+func (s *Simulation) ApplyState(st *State) {
+	walls := make([]bool, st.Width*st.Height)
+	heat := make([]int8, st.Width*st.Height)
+	materials := make([]WallMaterial, st.Width*st.Height)
+	for y, row := range st.Walls {
+		if y >= st.Height {
+			break
+		}
+		for x := 0; x < len(row) && x < st.Width; x++ {
+			idx := x + y*st.Width
+			switch row[x] {
+			case hotCell:
+				heat[idx] = HeatHot
+			case coldCell:
+				heat[idx] = HeatCold
+			}
+			walls[idx] = heat[idx] != HeatNone
+			for mat, c := range materialCells {
+				if row[x] == c {
+					walls[idx] = true
+					materials[idx] = WallMaterial(mat)
+				}
+			}
+		}
+	}
+
+	// saved species are found by name, unknown ones fall back to the first species
+	var remap []int
+	if len(st.Species) > 0 {
+		remap = make([]int, len(st.Species))
+		for i, name := range st.Species {
+			remap[i] = max(s.speciesIndex(name), 0)
+		}
+	}
+	particles := make([]Particle, len(st.Particles))
+	for i, p := range st.Particles {
+		species := p.Species
+		if remap != nil && uint(species) < uint(len(remap)) {
+			species = remap[species]
+		}
+		particles[i] = Particle{
+			Pos:     Vector{X: p.X, Y: p.Y},
+			OldPos:  Vector{X: p.OldX, Y: p.OldY},
+			Species: species,
+			Temp:    p.Temp,
+		}
+	}
+
+	s.LoadState(st.Width, st.Height, walls, heat, materials, particles)
+	s.setSources(st.Sources)
+	s.setSprings(st.Springs)
+	s.setBodies(st.Bodies)
+	if scale := s.velocityScale(); scale != 1 {
+		s.rescaleVelocities(scale)
+	}
+}
+
+// CheckSpecies reports an error when the particles use a species the table lacks, by
+// name for states that saved their species and by index for older ones
+func (st *State) CheckSpecies(species []config.Species) error {
+	count := len(species)
+	if len(st.Species) > 0 {
+		count = len(st.Species)
+	}
+	used := make([]bool, count)
+	for _, p := range st.Particles {
+		if p.Species < 0 || p.Species >= count {
+			return fmt.Errorf("particles use species %d, only %d are defined", p.Species, count)
+		}
+		used[p.Species] = true
+	}
+	defined := make(map[string]bool, len(species))
+	for _, sp := range species {
+		defined[sp.Name] = true
+	}
+	for i, name := range st.Species {
+		if used[i] && !defined[name] {
+			return fmt.Errorf("species %q is not defined", name)
+		}
+	}
+	return nil
+}
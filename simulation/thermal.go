@@ -0,0 +1,105 @@
+package simulation
+
+import "math"
+
+// temperatures of hot and cold wall cells
+const (
+	HotCellTemp  = 1500.0
+	ColdCellTemp = -50.0
+)
+
+// heat markers of wall cells
+const (
+	HeatNone int8 = 0
+	HeatHot  int8 = 1
+	HeatCold int8 = -1
+)
+
+// SetHeat marks a wall cell as hot or cold, non-wall cells become walls
+func (s *Simulation) SetHeat(x, y int, heat int8) {
+	if uint(x) < uint(s.Width) && uint(y) < uint(s.Height) {
+		idx := x + y*s.Width
+		if s.Heat[idx] != heat {
+			s.Heat[idx] = heat
+			s.wallVersion++
+		}
+		if heat != HeatNone {
+			s.Walls[idx] = true
+			s.wallsChanged()
+			s.hasHeat = true
+		}
+	}
+}
+
+func (s *Simulation) updateHeatFlag() {
+	s.hasHeat = false
+	for _, h := range s.Heat {
+		if h != HeatNone {
+			s.hasHeat = true
+			return
+		}
+	}
+}
+
+// viscosityScale makes fluid thicker the further it is below the ambient temperature
+func viscosityScale(temp, ambient, coeff float64) float64 {
+	scale := math.Exp(coeff * (ambient - temp) / 100)
+	return math.Max(0.1, math.Min(scale, 10))
+}
+
+// UpdateTemperature runs the per-frame heat exchange with the surroundings and the
+// phase changes. Diffusion between particles happens in the viscosity pass.
+func (s *Simulation) UpdateTemperature() {
+	ambient := s.Config.AmbientTemp
+	// the rates are per base frame
+	length := s.frameLength()
+	cooling := s.Config.CoolingRate * length
+	conductivity := math.Min(s.Config.Conductivity*length, 1)
+	mats := s.materials
+
+	for i := range s.Particles {
+		p := &s.Particles[i]
+
+		if cooling != 0 {
+			p.Temp += (ambient - p.Temp) * cooling
+		}
+		if s.hasHeat && conductivity > 0 {
+			s.exchangeCellHeat(p, conductivity)
+		}
+
+		m := mats[p.Species]
+		next := -1
+		if m.coolInto >= 0 && p.Temp < m.coolBelow {
+			next = m.coolInto
+		} else if m.heatInto >= 0 && p.Temp > m.heatAbove {
+			next = m.heatInto
+		}
+		if next >= 0 {
+			p.Species = next
+			if mats[next].static {
+				p.OldPos = p.Pos
+			}
+		}
+	}
+}
+
+// exchangeCellHeat moves the temperature towards the hot and cold cells around p
+func (s *Simulation) exchangeCellHeat(p *Particle, conductivity float64) {
+	cx, cy := int(p.Pos.X), int(p.Pos.Y)
+	for y := cy - 1; y <= cy+1; y++ {
+		if uint(y) >= uint(s.Height) {
+			continue
+		}
+		for x := cx - 1; x <= cx+1; x++ {
+			if uint(x) >= uint(s.Width) {
+				continue
+			}
+			switch s.Heat[x+y*s.Width] {
+			case HeatHot:
+				p.Temp += (HotCellTemp - p.Temp) * conductivity * 0.25
+			case HeatCold:
+				p.Temp += (ColdCellTemp - p.Temp) * conductivity * 0.25
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package simulation
+
+import (
+	"math"
+	"time"
+)
+
+// Simulated time is counted in base frames, one step at DefaultStepRate and normal
+// speed. A step is split into substeps and particle velocities are displacements per
+// substep, so they are rescaled whenever the substep length changes.
+
+const (
+	// DefaultStepRate is the number of steps Run takes per second at normal speed
+	DefaultStepRate = 60.0
+	// MaxSubSteps bounds the adaptive substeps of one step, past it the velocity clamp
+	// keeps the fastest particles in check
+	MaxSubSteps = 16
+	// cflNumber is the part of the interaction radius the fastest particle or body may
+	// move per substep before more substeps are used
+	cflNumber = 0.5
+	// maxStepsPerTick bounds the steps Run catches up on in one tick, which also stop
+	// once they took longer than a tick. The rest of the backlog is dropped, so a machine
+	// that cannot keep up runs slower instead of falling further behind.
+	maxStepsPerTick = 8
+)
+
+// TimeScales are the speeds the time scale steps through, 1 is normal speed
+var TimeScales = []float64{0.125, 0.25, 0.5, 1, 2, 4}
+
+// ClampTimeScale limits a time scale to the range of TimeScales, zero or less is normal speed
+func ClampTimeScale(scale float64) float64 {
+	if scale <= 0 {
+		return 1
+	}
+	return min(max(scale, TimeScales[0]), TimeScales[len(TimeScales)-1])
+}
+
+// StepInterval is the real time between the steps of Run
+func (s *Simulation) StepInterval() time.Duration {
+	return time.Duration(float64(time.Second) / s.stepRate())
+}
+
+func (s *Simulation) stepRate() float64 {
+	if s.StepRate <= 0 {
+		return DefaultStepRate
+	}
+	return s.StepRate
+}
+
+// frameLength is the simulated time of one step in base frames. Slow motion shortens
+// the steps, fast forward takes more of them instead so it costs no accuracy.
+func (s *Simulation) frameLength() float64 {
+	length := DefaultStepRate / s.stepRate()
+	if s.TimeScale > 0 && s.TimeScale < 1 {
+		length *= s.TimeScale
+	}
+	return length
+}
+
+// planSubsteps picks the substeps of the next frame: SubSteps per base frame, more when
+// the fastest particle or body would move further than cflNumber interaction radii in
+// one. Velocities are rescaled to the new substep length.
+func (s *Simulation) planSubsteps() {
+	length := s.frameLength()
+	n := max(int(math.Ceil(float64(SubSteps)*length-1e-9)), 1)
+
+	// the fastest speed in cells per base frame, covered over the whole step
+	if limit := cflNumber * s.Config.InteractionRad; limit > 0 {
+		speed := s.maxSpeed() / s.subDt
+		n = max(n, int(math.Ceil(speed*length/limit)))
+	}
+	n = min(n, MaxSubSteps)
+
+	dt := length / float64(n)
+	if dt != s.subDt {
+		s.rescaleVelocities(dt / s.subDt)
+	}
+	s.subSteps, s.subDt = n, dt
+}
+
+// SubStepCount returns the number of substeps of the last step
+func (s *Simulation) SubStepCount() int {
+	return s.subSteps
+}
+
+// maxSpeed returns the largest particle or body displacement per substep
+func (s *Simulation) maxSpeed() float64 {
+	maxSq := 0.0
+	for i := range s.Particles {
+		p := &s.Particles[i]
+		vx, vy := p.Pos.X-p.OldPos.X, p.Pos.Y-p.OldPos.Y
+		maxSq = max(maxSq, vx*vx+vy*vy)
+	}
+	for i := range s.Bodies {
+		b := &s.Bodies[i]
+		maxSq = max(maxSq, b.VX*b.VX+b.VY*b.VY)
+	}
+	return math.Sqrt(maxSq)
+}
+
+// maxMove is the velocity clamp of particles and bodies, in cells per substep
+func (s *Simulation) maxMove() float64 {
+	if s.Config.InteractionRad > 0 {
+		return s.Config.InteractionRad
+	}
+	return 3
+}
+
+// rescaleVelocities multiplies the particle and body velocities by ratio, the old
+// positions move so the current positions stay where they are
+func (s *Simulation) rescaleVelocities(ratio float64) {
+	for i := range s.Particles {
+		p := &s.Particles[i]
+		p.OldPos.X = p.Pos.X - (p.Pos.X-p.OldPos.X)*ratio
+		p.OldPos.Y = p.Pos.Y - (p.Pos.Y-p.OldPos.Y)*ratio
+	}
+	for i := range s.Bodies {
+		b := &s.Bodies[i]
+		b.VX *= ratio
+		b.VY *= ratio
+		b.Spin *= ratio
+	}
+}
+
+// velocityScale is the length of the current substeps relative to the ones at the
+// default rate. Saved states and new particles use velocities per default substep.
+func (s *Simulation) velocityScale() float64 {
+	return s.subDt * SubSteps
+}
+
+// substepScale is what accelerations are multiplied with for a substep of dt base
+// frames. It is dt at the default substep length and falls with dt squared, as the
+// velocities it adds to are measured per substep as well.
+func substepScale(dt float64) float64 {
+	return dt * dt * SubSteps
+}
+
+// substepDamping converts a velocity factor per default substep to one substep of dt base frames
+func substepDamping(damping, dt float64) float64 {
+	return math.Pow(damping, dt*SubSteps)
+}
@@ -0,0 +1,324 @@
+package simulation
+
+import (
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/null-enjoyer/terminal-fluid-simulation/render"
+)
+
+const (
+	// maxWallDist caps the distance field far away from walls
+	maxWallDist = 64.0
+	// wallSkin keeps particles pushed out of a wall clear of its surface
+	wallSkin = 0.01
+	// edtInf stands in for an infinite squared distance without producing NaNs
+	edtInf = 1e20
+)
+
+// WallMaterial decides how particles bounce off a wall cell
+type WallMaterial int8
+
+const (
+	WallDefault WallMaterial = iota // uses the wall friction and restitution of the config
+	WallSlippery
+	WallSticky
+	WallBouncy
+)
+
+// WallMaterialNames are the display names of the wall materials
+var WallMaterialNames = []string{"Default", "Slippery", "Sticky", "Bouncy"}
+
+// wallMaterials are the coefficients of the materials other than the default. Slippery
+// walls also take no part in adhesion.
+var wallMaterials = [...]struct{ friction, restitution float64 }{
+	WallSlippery: {0, 0},
+	WallSticky:   {0.9, 0},
+	WallBouncy:   {0, 0.9},
+}
+
+// ParseWallMaterial looks a material up by its name, ignoring case
+func ParseWallMaterial(name string) (WallMaterial, bool) {
+	for i, n := range WallMaterialNames {
+		if strings.EqualFold(n, name) {
+			return WallMaterial(i), true
+		}
+	}
+	return WallDefault, false
+}
+
+// SetWallMaterial changes the material of a wall cell, unknown materials become the default
+func (s *Simulation) SetWallMaterial(x, y int, mat WallMaterial) {
+	if uint(x) < uint(s.Width) && uint(y) < uint(s.Height) {
+		if mat < 0 || int(mat) >= len(WallMaterialNames) {
+			mat = WallDefault
+		}
+		if s.WallMaterials[x+y*s.Width] != mat {
+			s.WallMaterials[x+y*s.Width] = mat
+			s.wallVersion++
+		}
+	}
+}
+
+// wallsChanged marks the distance field and the renderer copy of the walls as outdated
+func (s *Simulation) wallsChanged() {
+	s.wallsDirty = true
+	s.wallVersion++
+}
+
+// wallSnapshot returns the wall cells for the renderer, copied again only after they changed
+func (s *Simulation) wallSnapshot() *render.Walls {
+	if c := s.wallCopy; c != nil && c.Version == s.wallVersion && c.Width == s.Width && c.Height == s.Height {
+		return c
+	}
+	c := &render.Walls{
+		Version:   s.wallVersion,
+		Width:     s.Width,
+		Height:    s.Height,
+		Solid:     slices.Clone(s.Walls),
+		Heat:      slices.Clone(s.Heat),
+		Materials: make([]int8, len(s.WallMaterials)),
+	}
+	for i, mat := range s.WallMaterials {
+		c.Materials[i] = int8(mat)
+	}
+	s.wallCopy = c
+	return c
+}
+
+// updateWallField rebuilds the signed distance field of the walls after they changed.
+// Distances are measured at cell centers to the nearest cell of the other kind, so the
+// zero crossing lies on the cell border. They are positive outside walls and negative
+// inside. The normals are the normalized gradient of the field and point out of walls.
+func (s *Simulation) updateWallField() {
+	s.wallsDirty = false
+	w, h := s.Width, s.Height
+	n := w * h
+	if len(s.wallDist) != n {
+		s.wallDist = make([]float32, n)
+		s.wallNX = make([]float32, n)
+		s.wallNY = make([]float32, n)
+	}
+
+	s.hasWalls = false
+	for _, wall := range s.Walls {
+		if wall {
+			s.hasWalls = true
+			break
+		}
+	}
+	if !s.hasWalls {
+		for i := range s.wallDist {
+			s.wallDist[i] = maxWallDist
+		}
+		clear(s.wallNX)
+		clear(s.wallNY)
+		return
+	}
+
+	toWall := s.distanceTransform(true)
+	for i, d := range toWall {
+		if !s.Walls[i] {
+			s.wallDist[i] = float32(math.Min(math.Sqrt(d)-0.5, maxWallDist))
+		}
+	}
+	toOpen := s.distanceTransform(false)
+	for i, d := range toOpen {
+		if s.Walls[i] {
+			s.wallDist[i] = -float32(math.Min(math.Sqrt(d)-0.5, maxWallDist))
+		}
+	}
+
+	dist := s.wallDist
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			x0, x1 := max(x-1, 0), min(x+1, w-1)
+			y0, y1 := max(y-1, 0), min(y+1, h-1)
+			gx := (dist[x1+y*w] - dist[x0+y*w]) / float32(max(x1-x0, 1))
+			gy := (dist[x+y1*w] - dist[x+y0*w]) / float32(max(y1-y0, 1))
+			length := float32(math.Sqrt(float64(gx*gx + gy*gy)))
+			if length > 1e-6 {
+				gx /= length
+				gy /= length
+			} else {
+				gx, gy = 0, 0
+			}
+			s.wallNX[x+y*w] = gx
+			s.wallNY[x+y*w] = gy
+		}
+	}
+}
+
+// distanceTransform returns the squared distance of every cell to the nearest cell with
+// Walls equal to wall, using the separable exact transform of Felzenszwalb and Huttenlocher
+func (s *Simulation) distanceTransform(wall bool) []float64 {
+	w, h := s.Width, s.Height
+	if cap(s.edt.grid) < w*h {
+		s.edt.grid = make([]float64, w*h)
+	}
+	grid := s.edt.grid[:w*h]
+	for i, c := range s.Walls {
+		if c == wall {
+			grid[i] = 0
+		} else {
+			grid[i] = edtInf
+		}
+	}
+
+	size := max(w, h)
+	if len(s.edt.f) < size {
+		s.edt.f = make([]float64, size)
+		s.edt.d = make([]float64, size)
+		s.edt.z = make([]float64, size+1)
+		s.edt.v = make([]int, size)
+	}
+	f, d := s.edt.f, s.edt.d
+
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			f[y] = grid[x+y*w]
+		}
+		s.edt1d(h)
+		for y := 0; y < h; y++ {
+			grid[x+y*w] = d[y]
+		}
+	}
+	for y := 0; y < h; y++ {
+		copy(f[:w], grid[y*w:(y+1)*w])
+		s.edt1d(w)
+		copy(grid[y*w:(y+1)*w], d[:w])
+	}
+	return grid
+}
+
+// edt1d transforms the first n values of edt.f into edt.d, the lower envelope of the
+// parabolas rooted at every sample
+func (s *Simulation) edt1d(n int) {
+	f, d, z, v := s.edt.f, s.edt.d, s.edt.z, s.edt.v
+
+	k := 0
+	v[0] = 0
+	z[0] = -edtInf
+	z[1] = edtInf
+	for q := 1; q < n; q++ {
+		sect := parabolaIntersect(f, q, v[k])
+		for sect <= z[k] {
+			k--
+			sect = parabolaIntersect(f, q, v[k])
+		}
+		k++
+		v[k] = q
+		z[k] = sect
+		z[k+1] = edtInf
+	}
+
+	k = 0
+	for q := 0; q < n; q++ {
+		for z[k+1] < float64(q) {
+			k++
+		}
+		dq := float64(q - v[k])
+		d[q] = dq*dq + f[v[k]]
+	}
+}
+
+// parabolaIntersect is where the parabolas rooted at q and r cross, finite values keep
+// it above -edtInf so the envelope search always stops at the first parabola
+func parabolaIntersect(f []float64, q, r int) float64 {
+	return ((f[q] + float64(q*q)) - (f[r] + float64(r*r))) / float64(2*q-2*r)
+}
+
+// wallDistance samples the signed distance to the nearest wall surface at x, y
+func (s *Simulation) wallDistance(x, y float64) float64 {
+	return s.sampleField(s.wallDist, x, y)
+}
+
+// wallNormal samples the direction out of the nearest wall at x, y. It reports false
+// where the field has no slope, like the middle of a wall one cell thick.
+func (s *Simulation) wallNormal(x, y float64) (Vector, bool) {
+	nx := s.sampleField(s.wallNX, x, y)
+	ny := s.sampleField(s.wallNY, x, y)
+	length := math.Sqrt(nx*nx + ny*ny)
+	if length < 1e-3 {
+		return Vector{}, false
+	}
+	return Vector{X: nx / length, Y: ny / length}, true
+}
+
+// sampleField interpolates a per-cell field bilinearly between cell centers
+func (s *Simulation) sampleField(field []float32, x, y float64) float64 {
+	w, h := s.Width, s.Height
+	fx := math.Max(0, math.Min(x-0.5, float64(w-1)))
+	fy := math.Max(0, math.Min(y-0.5, float64(h-1)))
+	ix := min(int(fx), w-2)
+	iy := min(int(fy), h-2)
+	if ix < 0 || iy < 0 {
+		return float64(field[0])
+	}
+	tx, ty := fx-float64(ix), fy-float64(iy)
+
+	i := ix + iy*w
+	top := float64(field[i])*(1-tx) + float64(field[i+1])*tx
+	bottom := float64(field[i+w])*(1-tx) + float64(field[i+w+1])*tx
+	return top*(1-ty) + bottom*ty
+}
+
+// projectOut moves a point at distance dist inside a wall out along the normals. Near
+// the middle of a thick wall the normals of both sides blend, so it may take a few moves.
+// It returns the free point and the normal of the last move.
+func (s *Simulation) projectOut(p Vector, dist float64) (Vector, Vector, bool) {
+	for iter := 0; iter < 4; iter++ {
+		n, ok := s.wallNormal(p.X, p.Y)
+		if !ok {
+			return p, n, false
+		}
+		p.X += n.X * (wallSkin - dist)
+		p.Y += n.Y * (wallSkin - dist)
+		if uint(int(p.X)) >= uint(s.Width) || uint(int(p.Y)) >= uint(s.Height) {
+			return p, n, false
+		}
+		if dist = s.wallDistance(p.X, p.Y); dist >= 0 {
+			return p, n, true
+		}
+	}
+	return p, Vector{}, false
+}
+
+// bounce returns the velocity after hitting the wall at hit with normal n, using the
+// coefficients of the material there
+func (s *Simulation) bounce(vx, vy float64, n, hit Vector) (float64, float64) {
+	friction, restitution := s.wallCoefficients(hit, n)
+	return reflect(vx, vy, n, friction, restitution)
+}
+
+// wallCoefficients returns the friction and restitution of the wall cell at p. Points
+// found through the interpolated field may lie just outside the cell, so it also looks
+// one cell further into the wall.
+func (s *Simulation) wallCoefficients(p, n Vector) (float64, float64) {
+	mat := WallDefault
+	for _, q := range [2]Vector{p, {X: p.X - n.X, Y: p.Y - n.Y}} {
+		ix, iy := int(q.X), int(q.Y)
+		if uint(ix) < uint(s.Width) && uint(iy) < uint(s.Height) && s.Walls[ix+iy*s.Width] {
+			mat = s.WallMaterials[ix+iy*s.Width]
+			break
+		}
+	}
+	if mat == WallDefault {
+		return s.Config.WallFriction, s.Config.WallRestitution
+	}
+	m := wallMaterials[mat]
+	return m.friction, m.restitution
+}
+
+// reflect returns the velocity after hitting a surface with normal n. The part going
+// into the surface is reflected by the restitution, the part along it slowed by the friction.
+func reflect(vx, vy float64, n Vector, friction, restitution float64) (float64, float64) {
+	vn := vx*n.X + vy*n.Y
+	if vn >= 0 {
+		return vx, vy
+	}
+	tx, ty := vx-n.X*vn, vy-n.Y*vn
+	keep := 1 - friction
+	return tx*keep - n.X*vn*restitution, ty*keep - n.Y*vn*restitution
+}
@@ -1,10 +1,14 @@
 package ui
 
-import "github.com/gdamore/tcell/v2"
+import (
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
 
 type MenuItem struct {
 	Name string
-	Type string // float, int, enum, action
+	Type string // float, tool, int, enum, toggle, action
 	Val  any    // pointer to the config value, nil for action
 	Step float64
 	Fmt  string
@@ -16,6 +20,16 @@ func DrawText(s tcell.Screen, x, y int, style tcell.Style, str string) {
 	}
 }
 
+// DrawBytes draws text kept in a reused buffer like DrawText, without converting it to
+// a string first
+func DrawBytes(s tcell.Screen, x, y int, style tcell.Style, text []byte) {
+	for i := 0; i < len(text); {
+		c, size := utf8.DecodeRune(text[i:])
+		s.SetContent(x+i, y, c, nil, style)
+		i += size
+	}
+}
+
 func DrawBox(s tcell.Screen, x, y, w, h int, style tcell.Style) {
 	for i := 0; i < w; i++ {
 		for j := 0; j < h; j++ {